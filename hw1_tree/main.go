@@ -1,86 +1,176 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"os"
-	"bytes"
-	"strings"	
+	"strings"
+
+	"hw1_tree/tree"
 )
 
+const usage = "usage go run main.go . [-f] [-format ascii|json|html] [-zip file | -tar file]\n" +
+	"      [-include glob] [-exclude glob] [-gitignore] [-prune] [-L depth] [-du] [-h] [-l] [-j jobs]\n" +
+	"      [-sort keys] [-p] [-u] [-g] [-D] [-timefmt layout] [-hash]\n" +
+	"      go run main.go diff old new [flags]"
+
 func main() {
-	if !(len(os.Args) == 2 || len(os.Args) == 3) {
-		panic("usage go run main.go . [-f]")
+	if len(os.Args) >= 3 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) < 2 {
+		panic(usage)
+	}
+
+	var zipPath, tarPath string
+	flags, opts, format := newFlags()
+	flags.StringVar(&zipPath, "zip", "", "print the tree of a zip archive, the path is taken inside it")
+	flags.StringVar(&tarPath, "tar", "", "print the tree of a tar or tar.gz archive, the path is taken inside it")
+	flags.Parse(os.Args[2:])
+
+	fsys, closer, err := openFS(os.Args[1], zipPath, tarPath)
+	if err == nil {
+		defer closer.Close()
+		out := bufio.NewWriter(os.Stdout)
+		err = tree.Render(out, fsys, *opts, *format)
+		out.Flush()
 	}
-	path := os.Args[1]
-	printFiles := len(os.Args) == 3 && os.Args[2] == "-f"
-	out := new(bytes.Buffer)
-	err := dirTree(out, path, printFiles)
-	out.WriteTo(os.Stdout)
 	if err != nil {
-		panic(err.Error())
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
-func dirTree(output *bytes.Buffer, path string, printFiles bool) error {	
-	var dirTree strings.Builder
-	printDirErr := printDir("", path, &dirTree, printFiles)
-	if printDirErr != nil {
-		return fmt.Errorf("error in printing dir: %v", printDirErr.Error())
+// runDiff compares two directories, archives or json snapshots of them.
+// Like diff(1) it exits with 1 when they differ and with 2 on errors.
+func runDiff(args []string) {
+	if len(args) < 2 {
+		panic(usage)
 	}
 
-	output.WriteString(dirTree.String())
-
-	return nil
-}
+	flags, opts, format := newFlags()
+	flags.Parse(args[2:])
+	opts.PrintFiles = true
 
-func printDir(prefix string, path string, result *strings.Builder, printFiles bool) error {
-	list, err := ioutil.ReadDir(path)
-	if err != nil {		
-		return fmt.Errorf(err.Error())
+	before, err := loadInput(args[0], *opts)
+	var after []*tree.Node
+	if err == nil {
+		after, err = loadInput(args[1], *opts)
 	}
-
-	if !printFiles {
-		list = filterFiles(list)
+	changes := 0
+	if err == nil {
+		out := bufio.NewWriter(os.Stdout)
+		changes, err = tree.Diff(out, before, after, *opts, *format)
+		out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	if changes > 0 {
+		os.Exit(1)
+	}
+}
 
-	for pos, item := range list {
-		isLast := pos == len(list) - 1
-		itemPrefix := "├───"
-		nextLevelPrefix := "│	"
-		if (isLast) {
-			itemPrefix = "└───"
-			nextLevelPrefix = "	"
-		}
+// newFlags defines the flags shared by the tree and diff commands, the
+// returned options are filled in once the flags are parsed.
+func newFlags() (*flag.FlagSet, *tree.Options, *string) {
+	opts := &tree.Options{}
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.BoolVar(&opts.PrintFiles, "f", false, "print files")
+	format := flags.String("format", "ascii", "output format: ascii, json or html")
+	flags.Var((*tree.Patterns)(&opts.Include), "include", "show only files matching glob (repeatable)")
+	flags.Var((*tree.Patterns)(&opts.Exclude), "exclude", "hide entries matching glob (repeatable)")
+	flags.BoolVar(&opts.Gitignore, "gitignore", false, "hide entries ignored by .gitignore files")
+	flags.BoolVar(&opts.Prune, "prune", false, "hide directories left empty after filtering")
+	flags.IntVar(&opts.MaxDepth, "L", 0, "descend only depth levels deep (0 means no limit)")
+	flags.BoolVar(&opts.DU, "du", false, "show cumulative directory sizes and a summary footer")
+	flags.BoolVar(&opts.Human, "h", false, "print sizes in a human readable format")
+	flags.BoolVar(&opts.Follow, "l", false, "follow symlinks to directories")
+	flags.IntVar(&opts.Jobs, "j", 1, "number of directories read in parallel")
+	flags.Func("sort", "comma separated sort keys: name, size, mtime, ext, dirsfirst", func(value string) error {
+		opts.SortBy = strings.Split(value, ",")
+		return nil
+	})
+	flags.BoolVar(&opts.Perms, "p", false, "show permissions")
+	flags.BoolVar(&opts.Owner, "u", false, "show file owner")
+	flags.BoolVar(&opts.Group, "g", false, "show file group")
+	flags.BoolVar(&opts.MTime, "D", false, "show modification time")
+	flags.StringVar(&opts.TimeFormat, "timefmt", tree.DefaultTimeFormat, "modification time layout, see package time")
+	flags.BoolVar(&opts.Hash, "hash", false, "show sha256 of file contents")
+	return flags, opts, format
+}
 
-		if (item.IsDir()) {
-			result.WriteString(fmt.Sprintf("%s%s%s\n", prefix, itemPrefix, item.Name()))
-			printDir(prefix + nextLevelPrefix, path + "/" + item.Name(), result, printFiles)
-		} else {
-			printFile(prefix + itemPrefix, item, result)
+// openFS opens path on disk, or inside the zip or tar archive when one is
+// given.
+func openFS(path, zipPath, tarPath string) (fs.FS, io.Closer, error) {
+	var fsys fs.FS
+	var closer io.Closer = io.NopCloser(nil)
+	switch {
+	case zipPath != "":
+		archive, err := zip.OpenReader(zipPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		fsys, closer = archive, archive
+	case tarPath != "":
+		file, err := os.Open(tarPath)
+		if err != nil {
+			return nil, nil, err
 		}
+		defer file.Close()
+		if fsys, err = tree.NewTarFS(file); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return os.DirFS(path), closer, nil
 	}
 
-	return nil
+	sub, err := fs.Sub(fsys, strings.Trim(path, "/"))
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	return sub, closer, nil
 }
 
-func printFile(prefix string, file os.FileInfo, result *strings.Builder) {
-	result.WriteString(fmt.Sprintf("%s%s (%s)\n", prefix, file.Name(), formatSize(file.Size())))		
-}
+// loadInput reads a diff side: a directory, a zip or tar archive or a json
+// snapshot, told apart by the file name.
+func loadInput(path string, opts tree.Options) ([]*tree.Node, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
 
-func formatSize(size int64) string {
-	if (size > 0) {
-		return fmt.Sprintf("%db", size)
-	} 
-		
-	return "empty"
+	var fsys fs.FS
+	var closer io.Closer
+	switch {
+	case info.IsDir():
+		fsys, closer, err = openFS(path, "", "")
+	case strings.HasSuffix(path, ".zip"):
+		fsys, closer, err = openFS(".", path, "")
+	case strings.HasSuffix(path, ".tar") || strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz"):
+		fsys, closer, err = openFS(".", "", path)
+	default:
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return tree.LoadSnapshot(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return tree.Load(fsys, opts)
 }
 
-func filterFiles(items []os.FileInfo) (result []os.FileInfo) {
-	for _, item := range items {
-			if item.IsDir() {
-					result = append(result, item)					
-			}			
-	}
-	return
-}
\ No newline at end of file
+func dirTree(output io.Writer, path string, printFiles bool) error {
+	return tree.Render(output, os.DirFS(path), tree.Options{PrintFiles: printFiles}, "ascii")
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"testing"
+
+	"hw1_tree/tree"
 )
 
 const testFullResult = `├───project
@@ -73,3 +75,19 @@ func TestTreeDir(t *testing.T) {
 		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDirResult)
 	}
 }
+
+func TestLoadInput(t *testing.T) {
+	opts := tree.Options{PrintFiles: true}
+	before, err := loadInput("testdata", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := loadInput("testdata", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := tree.Diff(new(bytes.Buffer), before, after, opts, "ascii")
+	if err != nil || changes != 0 {
+		t.Errorf("expected no changes, got %d (%v)", changes, err)
+	}
+}
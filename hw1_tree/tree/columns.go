@@ -0,0 +1,71 @@
+package tree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// idNames caches user and group names by id, falling back to the number
+// when there is no such user or group.
+type idNames struct {
+	mu     sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func (n *idNames) user(uid uint32) string {
+	return n.lookup(&n.users, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+func (n *idNames) group(gid uint32) string {
+	return n.lookup(&n.groups, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+func (n *idNames) lookup(cache *map[uint32]string, id uint32, find func(string) (string, error)) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if name, ok := (*cache)[id]; ok {
+		return name
+	}
+
+	name, err := find(strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		name = strconv.FormatUint(uint64(id), 10)
+	}
+	if *cache == nil {
+		*cache = map[uint32]string{}
+	}
+	(*cache)[id] = name
+	return name
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,225 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+const (
+	added   = "added"
+	removed = "removed"
+	changed = "changed"
+)
+
+// Node is an entry kept in memory together with its children, as returned
+// by Load and LoadSnapshot.
+type Node struct {
+	entry
+	children []*Node
+}
+
+// treeBuilder is a renderer collecting the walked entries into nodes.
+type treeBuilder struct {
+	root  Node
+	stack []*Node
+}
+
+func (b *treeBuilder) begin() error {
+	b.stack = []*Node{&b.root}
+	return nil
+}
+
+func (b *treeBuilder) entry(e entry, isLast bool) error {
+	parent := b.stack[len(b.stack)-1]
+	n := &Node{entry: e}
+	parent.children = append(parent.children, n)
+	if e.isDir {
+		b.stack = append(b.stack, n)
+	}
+	return nil
+}
+
+func (b *treeBuilder) leave() error {
+	b.stack = b.stack[:len(b.stack)-1]
+	return nil
+}
+
+func (b *treeBuilder) end(summary *stats) error { return nil }
+
+// Load walks fsys like Render does and returns its first level.
+func Load(fsys fs.FS, opts Options) ([]*Node, error) {
+	b := &treeBuilder{}
+	if err := b.begin(); err != nil {
+		return nil, err
+	}
+	w, err := newWalker(fsys, opts, b)
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+	if err := w.walk(); err != nil {
+		return nil, err
+	}
+	if len(w.errs) > 0 {
+		return nil, w.errs
+	}
+	return b.root.children, nil
+}
+
+type snapshotNode struct {
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Target    string          `json:"target"`
+	Size      int64           `json:"size"`
+	Hash      string          `json:"hash"`
+	Truncated bool            `json:"truncated"`
+	Children  *[]snapshotNode `json:"children"`
+}
+
+// LoadSnapshot reads a tree written with the json format.
+func LoadSnapshot(r io.Reader) ([]*Node, error) {
+	var items []snapshotNode
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, fmt.Errorf("bad snapshot: %v", err)
+	}
+	return snapshotNodes(items), nil
+}
+
+func snapshotNodes(items []snapshotNode) (result []*Node) {
+	for _, item := range items {
+		if item.Type == "report" {
+			continue
+		}
+		n := &Node{entry: entry{
+			name:      item.Name,
+			size:      item.Size,
+			isDir:     item.Children != nil,
+			mode:      snapshotModes[item.Type],
+			target:    item.Target,
+			hash:      item.Hash,
+			truncated: item.Truncated,
+		}}
+		if item.Children != nil {
+			n.children = snapshotNodes(*item.Children)
+		}
+		result = append(result, n)
+	}
+	return
+}
+
+// snapshotModes restores the file type bits from a snapshot "type".
+var snapshotModes = map[string]fs.FileMode{
+	"directory":    fs.ModeDir,
+	"link":         fs.ModeSymlink,
+	"fifo":         fs.ModeNamedPipe,
+	"socket":       fs.ModeSocket,
+	"char device":  fs.ModeDevice | fs.ModeCharDevice,
+	"block device": fs.ModeDevice,
+}
+
+// diffNodes merges the two sorted levels, marking the entries which were
+// added, removed or changed. It returns the merged level and the number of
+// changes in it and below it.
+func diffNodes(before, after []*Node) ([]*Node, int) {
+	byName := func(nodes []*Node) {
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	}
+	byName(before)
+	byName(after)
+
+	var result []*Node
+	changes := 0
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case j == len(after) || i < len(before) && before[i].name < after[j].name:
+			changes += mark(before[i], removed)
+			result = append(result, before[i])
+			i++
+		case i == len(before) || after[j].name < before[i].name:
+			changes += mark(after[j], added)
+			result = append(result, after[j])
+			j++
+		case before[i].kind() != after[j].kind() || before[i].isDir != after[j].isDir:
+			changes += mark(before[i], removed) + mark(after[j], added)
+			result = append(result, before[i], after[j])
+			i++
+			j++
+		default:
+			n := after[j]
+			// snapshots only have the sizes of regular files
+			if n.target != before[i].target || n.mode.IsRegular() && n.size != before[i].size ||
+				n.hash != "" && before[i].hash != "" && n.hash != before[i].hash {
+				n.change = changed
+				n.oldSize = before[i].size
+				n.oldTarget = before[i].target
+				changes++
+			}
+			// levels below the depth limit on either side are not compared
+			if n.truncated || before[i].truncated {
+				n.truncated = len(n.children) > 0 || len(before[i].children) > 0
+				n.children = nil
+			} else {
+				var childChanges int
+				n.children, childChanges = diffNodes(before[i].children, n.children)
+				changes += childChanges
+			}
+			result = append(result, n)
+			i++
+			j++
+		}
+	}
+	return result, changes
+}
+
+// mark sets change on n and its whole subtree and returns how many entries
+// it marked.
+func mark(n *Node, change string) int {
+	n.change = change
+	count := 1
+	for _, child := range n.children {
+		count += mark(child, change)
+	}
+	return count
+}
+
+// replay sends nodes to r in the order a walk would have.
+func replay(nodes []*Node, r renderer) error {
+	for pos, n := range nodes {
+		if err := r.entry(n.entry, pos == len(nodes)-1); err != nil {
+			return err
+		}
+		if !n.isDir {
+			continue
+		}
+		if err := replay(n.children, r); err != nil {
+			return err
+		}
+		if err := r.leave(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diff renders the union of the trees before and after with the added,
+// removed and changed entries marked and returns the number of differences.
+// It reorders and marks the given nodes.
+func Diff(output io.Writer, before, after []*Node, opts Options, format string) (int, error) {
+	r, err := newRenderer(format, output, opts)
+	if err != nil {
+		return 0, err
+	}
+	merged, changes := diffNodes(before, after)
+
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	if err := replay(merged, r); err != nil {
+		return 0, err
+	}
+	return changes, r.end(nil)
+}
@@ -0,0 +1,145 @@
+package tree
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Patterns collects repeated glob flags such as -include a -include b.
+type Patterns []string
+
+func (p *Patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *Patterns) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// matchAny reports whether name or its slash separated path below the tree
+// root matches one of globs. Patterns containing a slash are matched against
+// the path, all others against the base name.
+func matchAny(globs []string, name, itemPath string) bool {
+	for _, glob := range globs {
+		target := name
+		if strings.Contains(glob, "/") {
+			target = itemPath
+			glob = strings.TrimPrefix(glob, "/")
+		}
+		if matchPath(glob, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches a slash separated name against pattern, where each
+// segment is a path.Match glob and a "**" segment matches any number of
+// segments.
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); !ok || err != nil {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+type ignoreRule struct {
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func (rule ignoreRule) match(rel string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.base != "" {
+		if !strings.HasPrefix(rel, rule.base+"/") {
+			return false
+		}
+		rel = rel[len(rule.base)+1:]
+	}
+	if !rule.anchored {
+		rel = path.Base(rel)
+	}
+	return matchPath(rule.pattern, rel)
+}
+
+// ignored applies rules in order, later rules (including those from nested
+// .gitignore files) overriding earlier ones, so "!" lines can re-include
+// entries.
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.match(rel, isDir) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
+
+// readGitignore loads the rules of the .gitignore in dir, if there is one.
+func readGitignore(fsys fs.FS, dir string) ([]ignoreRule, error) {
+	data, err := fs.ReadFile(fsys, joinPath(dir, ".gitignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseGitignore(data, dir), nil
+}
+
+func parseGitignore(data []byte, base string) (rules []ignoreRule) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if rule.pattern == "" {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package tree
+
+import "sync"
+
+// listing is the result of a directory read scheduled on a readPool.
+type listing struct {
+	path  string
+	rules []ignoreRule
+
+	entries    []entry
+	childRules []ignoreRule
+	err        error
+	done       chan struct{}
+}
+
+func (l *listing) wait() ([]entry, []ignoreRule, error) {
+	<-l.done
+	return l.entries, l.childRules, l.err
+}
+
+// readPool lists directories on a fixed number of workers, so that sibling
+// directories are read in parallel while the walker still renders them in
+// order. Listings are served first in, first out, which keeps the ones the
+// walker needs next at the head of the queue.
+type readPool struct {
+	w    *walker
+	jobs chan *listing
+	wg   sync.WaitGroup
+}
+
+func newReadPool(w *walker, workers int) *readPool {
+	p := &readPool{w: w, jobs: make(chan *listing, workers*16)}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *readPool) work() {
+	defer p.wg.Done()
+	for l := range p.jobs {
+		l.entries, l.childRules, l.err = p.w.list(l.path, l.rules)
+		close(l.done)
+	}
+}
+
+func (p *readPool) submit(path string, rules []ignoreRule) *listing {
+	l := &listing{path: path, rules: rules, done: make(chan struct{})}
+	p.jobs <- l
+	return l
+}
+
+// close stops the workers once the submitted listings are done.
+func (p *readPool) close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
@@ -0,0 +1,365 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+type entry struct {
+	name  string
+	size  int64
+	isDir bool
+	mode  fs.FileMode
+
+	modTime time.Time
+	owner   string
+	group   string
+	hash    string
+
+	// target is set for symlinks, isDir then tells what they point to.
+	target string
+	// note is shown next to the entry, e.g. "permission denied".
+	note string
+	// truncated marks a non-empty directory whose children were not walked
+	// because of the depth limit.
+	truncated bool
+
+	// change is set by diff to added, removed or changed, the old values
+	// are kept for the changed entries.
+	change    string
+	oldSize   int64
+	oldTarget string
+}
+
+var changeMarks = map[string]string{added: "[+] ", removed: "[-] ", changed: "[~] "}
+
+// kind names the file type of e.
+func (e entry) kind() string {
+	switch {
+	case e.target != "":
+		return "link"
+	case e.isDir:
+		return "directory"
+	case e.mode&fs.ModeNamedPipe != 0:
+		return "fifo"
+	case e.mode&fs.ModeSocket != 0:
+		return "socket"
+	case e.mode&fs.ModeCharDevice != 0:
+		return "char device"
+	case e.mode&fs.ModeDevice != 0:
+		return "block device"
+	}
+	return "file"
+}
+
+const truncatedMark = "…"
+
+// renderer receives entries in walk order. Every directory entry is followed
+// by its children and then by a leave call closing it. end gets the summary
+// when a footer was requested and nil otherwise.
+type renderer interface {
+	begin() error
+	entry(e entry, isLast bool) error
+	leave() error
+	end(summary *stats) error
+}
+
+func newRenderer(format string, out io.Writer, opts Options) (renderer, error) {
+	switch format {
+	case "ascii", "":
+		return &asciiRenderer{out: out, opts: opts}, nil
+	case "json":
+		return &jsonRenderer{out: out, opts: opts}, nil
+	case "html":
+		return &htmlRenderer{out: out, opts: opts}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func formatSize(size int64, human bool) string {
+	switch {
+	case size <= 0:
+		return "empty"
+	case !human || size < 1024:
+		return fmt.Sprintf("%db", size)
+	}
+
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(sizeUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", value, sizeUnits[unit])
+}
+
+var sizeUnits = []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+
+// DefaultTimeFormat is the modification time layout used by tree -D.
+const DefaultTimeFormat = "Jan _2 15:04"
+
+// columns formats the requested metadata of e as "[perms owner group time hash] ".
+func columns(e entry, opts Options) string {
+	var fields []string
+	if opts.Perms {
+		fields = append(fields, e.mode.String())
+	}
+	if opts.Owner {
+		fields = append(fields, e.owner)
+	}
+	if opts.Group {
+		fields = append(fields, e.group)
+	}
+	if opts.MTime {
+		layout := opts.TimeFormat
+		if layout == "" {
+			layout = DefaultTimeFormat
+		}
+		fields = append(fields, e.modTime.Format(layout))
+	}
+	if opts.Hash && e.hash != "" {
+		fields = append(fields, e.hash)
+	}
+
+	if len(fields) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(fields, " ") + "] "
+}
+
+// label is the text shown for e in the text based formats.
+func label(e entry, opts Options) string {
+	result := changeMarks[e.change] + columns(e, opts) + e.name
+	if e.target != "" {
+		result += " -> " + e.target
+		if e.change == changed && e.oldTarget != e.target {
+			result += fmt.Sprintf(" (was %s)", e.oldTarget)
+		}
+	}
+
+	switch kind := e.kind(); {
+	case e.isDir && opts.DU:
+		result += fmt.Sprintf(" (%s)", formatSize(e.size, opts.Human))
+	case kind == "file" && e.change == changed:
+		result += fmt.Sprintf(" (%s -> %s)", formatSize(e.oldSize, opts.Human), formatSize(e.size, opts.Human))
+	case kind == "file":
+		result += fmt.Sprintf(" (%s)", formatSize(e.size, opts.Human))
+	case kind != "link" && kind != "directory":
+		result += " [" + kind + "]"
+	}
+
+	if e.truncated {
+		result += " " + truncatedMark
+	}
+	if e.note != "" {
+		result += " [" + e.note + "]"
+	}
+	return result
+}
+
+func footer(s *stats, opts Options) string {
+	total := fmt.Sprintf("%d bytes", s.size)
+	if opts.Human {
+		total = formatSize(s.size, true)
+	}
+	return fmt.Sprintf("%d directories, %d files, %s", s.dirs, s.files, total)
+}
+
+type asciiRenderer struct {
+	out     io.Writer
+	opts    Options
+	prefix  string
+	lengths []int
+}
+
+func (r *asciiRenderer) begin() error { return nil }
+
+func (r *asciiRenderer) entry(e entry, isLast bool) error {
+	itemPrefix := "├───"
+	nextLevelPrefix := "│\t"
+	if isLast {
+		itemPrefix = "└───"
+		nextLevelPrefix = "\t"
+	}
+
+	_, err := fmt.Fprintf(r.out, "%s%s%s\n", r.prefix, itemPrefix, label(e, r.opts))
+
+	if e.isDir {
+		r.lengths = append(r.lengths, len(r.prefix))
+		r.prefix += nextLevelPrefix
+	}
+	return err
+}
+
+func (r *asciiRenderer) leave() error {
+	last := len(r.lengths) - 1
+	r.prefix = r.prefix[:r.lengths[last]]
+	r.lengths = r.lengths[:last]
+	return nil
+}
+
+func (r *asciiRenderer) end(summary *stats) error {
+	if summary == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(r.out, "\n%s\n", footer(summary, r.opts))
+	return err
+}
+
+// jsonRenderer writes the tree as a single line array of nested
+// {"name", "type", "size", "children"} objects, followed by a
+// {"type": "report"} object when there is a footer. Directories, including
+// symlinks to them, are the objects with children.
+type jsonRenderer struct {
+	out   io.Writer
+	opts  Options
+	empty []bool
+}
+
+func (r *jsonRenderer) begin() error {
+	r.empty = []bool{true}
+	_, err := io.WriteString(r.out, "[")
+	return err
+}
+
+func (r *jsonRenderer) separator() string {
+	top := len(r.empty) - 1
+	if r.empty[top] {
+		r.empty[top] = false
+		return ""
+	}
+	return ","
+}
+
+func (r *jsonRenderer) entry(e entry, isLast bool) error {
+	sep := r.separator()
+	name, err := json.Marshal(e.name)
+	if err != nil {
+		return err
+	}
+
+	fields := fmt.Sprintf(`{"name":%s,"type":%q`, name, e.kind())
+	if e.target != "" {
+		target, err := json.Marshal(e.target)
+		if err != nil {
+			return err
+		}
+		fields += fmt.Sprintf(`,"target":%s`, target)
+	}
+	if e.mode.IsRegular() || e.isDir && r.opts.DU {
+		fields += fmt.Sprintf(`,"size":%d`, e.size)
+	}
+	if r.opts.Perms {
+		fields += `,"mode":` + jsonString(e.mode.String())
+	}
+	if r.opts.Owner {
+		fields += `,"owner":` + jsonString(e.owner)
+	}
+	if r.opts.Group {
+		fields += `,"group":` + jsonString(e.group)
+	}
+	if r.opts.MTime {
+		fields += `,"mtime":` + jsonString(e.modTime.Format(time.RFC3339))
+	}
+	if e.hash != "" {
+		fields += `,"hash":` + jsonString(e.hash)
+	}
+	if e.truncated {
+		fields += `,"truncated":true`
+	}
+	if e.note != "" {
+		fields += `,"note":` + jsonString(e.note)
+	}
+	if e.change != "" {
+		fields += fmt.Sprintf(`,"change":%q`, e.change)
+	}
+	if e.change == changed {
+		oldTarget, err := json.Marshal(e.oldTarget)
+		if err != nil {
+			return err
+		}
+		fields += fmt.Sprintf(`,"old_size":%d,"old_target":%s`, e.oldSize, oldTarget)
+	}
+
+	if !e.isDir {
+		_, err = fmt.Fprintf(r.out, "%s%s}", sep, fields)
+		return err
+	}
+	r.empty = append(r.empty, true)
+	_, err = fmt.Fprintf(r.out, `%s%s,"children":[`, sep, fields)
+	return err
+}
+
+// jsonString returns s as a JSON string. Invalid UTF-8 becomes U+FFFD.
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func (r *jsonRenderer) leave() error {
+	r.empty = r.empty[:len(r.empty)-1]
+	_, err := io.WriteString(r.out, "]}")
+	return err
+}
+
+func (r *jsonRenderer) end(summary *stats) error {
+	if summary != nil {
+		_, err := fmt.Fprintf(r.out, `%s{"type":"report","directories":%d,"files":%d,"size":%d}`,
+			r.separator(), summary.dirs, summary.files, summary.size)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(r.out, "]\n")
+	return err
+}
+
+type htmlRenderer struct {
+	out   io.Writer
+	opts  Options
+	depth int
+}
+
+func (r *htmlRenderer) indent(depth int) string {
+	return strings.Repeat("\t", depth)
+}
+
+func (r *htmlRenderer) begin() error {
+	r.depth = 1
+	_, err := io.WriteString(r.out, "<ul>\n")
+	return err
+}
+
+func (r *htmlRenderer) entry(e entry, isLast bool) error {
+	text := html.EscapeString(label(e, r.opts))
+	if !e.isDir {
+		_, err := fmt.Fprintf(r.out, "%s<li>%s</li>\n", r.indent(r.depth), text)
+		return err
+	}
+
+	_, err := fmt.Fprintf(r.out, "%s<li>%s\n%s<ul>\n", r.indent(r.depth), text, r.indent(r.depth+1))
+	r.depth += 2
+	return err
+}
+
+func (r *htmlRenderer) leave() error {
+	r.depth -= 2
+	_, err := fmt.Fprintf(r.out, "%s</ul>\n%s</li>\n", r.indent(r.depth+1), r.indent(r.depth))
+	return err
+}
+
+func (r *htmlRenderer) end(summary *stats) error {
+	if _, err := io.WriteString(r.out, "</ul>\n"); err != nil {
+		return err
+	}
+	if summary == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(r.out, "<p>%s</p>\n", html.EscapeString(footer(summary, r.opts)))
+	return err
+}
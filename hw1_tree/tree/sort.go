@@ -0,0 +1,71 @@
+package tree
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// sortKeys compare two entries of a level. Sizes and times are ordered
+// largest and newest first, like ls does.
+var sortKeys = map[string]func(a, b entry) int{
+	"name": func(a, b entry) int {
+		return strings.Compare(a.name, b.name)
+	},
+	"size": func(a, b entry) int {
+		return compareInt64(b.size, a.size)
+	},
+	"mtime": func(a, b entry) int {
+		return compareInt64(b.modTime.UnixNano(), a.modTime.UnixNano())
+	},
+	"ext": func(a, b entry) int {
+		return strings.Compare(path.Ext(a.name), path.Ext(b.name))
+	},
+	"dirsfirst": func(a, b entry) int {
+		switch {
+		case a.isDir == b.isDir:
+			return 0
+		case a.isDir:
+			return -1
+		}
+		return 1
+	},
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// newSorter returns a function ordering a level by keys, applied in turn.
+// Entries equal on all keys keep the name order they were read in.
+func newSorter(keys []string) (func(list []entry), error) {
+	var compare []func(a, b entry) int
+	for _, key := range keys {
+		cmp, ok := sortKeys[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort key %q", key)
+		}
+		compare = append(compare, cmp)
+	}
+
+	return func(list []entry) {
+		if len(compare) == 0 {
+			return
+		}
+		sort.SliceStable(list, func(i, j int) bool {
+			for _, cmp := range compare {
+				if c := cmp(list[i], list[j]); c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}, nil
+}
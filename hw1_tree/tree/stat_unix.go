@@ -0,0 +1,34 @@
+//go:build unix
+
+package tree
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a directory by device and inode.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func statID(fsys fs.FS, name string) (fileID, bool) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return fileID{}, false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+func fileOwner(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
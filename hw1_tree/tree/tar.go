@@ -0,0 +1,202 @@
+package tree
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewTarFS reads a whole tar archive, gzip compressed or not, into an
+// in-memory file system. Symlinks in it are listed but never followed.
+func NewTarFS(r io.Reader) (fs.FS, error) {
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buffered
+	}
+
+	fsys := &memFS{files: map[string]*memFile{}}
+	fsys.mkdirAll(".")
+	archive := tar.NewReader(r)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimLeft(hdr.Name, "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		info := hdr.FileInfo()
+		file := &memFile{
+			name:    path.Base(name),
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+			target:  hdr.Linkname,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if file.data, err = io.ReadAll(archive); err != nil {
+				return nil, err
+			}
+		case tar.TypeLink:
+			// hard links share the contents of an earlier entry
+			if linked, ok := fsys.files[path.Clean(hdr.Linkname)]; ok {
+				file.data = linked.data
+			}
+			file.mode = info.Mode().Perm()
+			file.target = ""
+		}
+		fsys.mkdirAll(path.Dir(name))
+		fsys.add(name, file)
+	}
+
+	for _, file := range fsys.files {
+		sort.Strings(file.children)
+	}
+	return fsys, nil
+}
+
+// memFS is a read only file system kept in memory, keyed by fs.FS names.
+type memFS struct {
+	files map[string]*memFile
+}
+
+type memFile struct {
+	name     string
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte
+	target   string
+	children []string
+}
+
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) Mode() fs.FileMode  { return f.mode }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return f.mode.IsDir() }
+func (f *memFile) Sys() interface{}   { return nil }
+
+func (fsys *memFS) add(name string, file *memFile) {
+	if existing, ok := fsys.files[name]; ok {
+		// a directory listed after its contents keeps them
+		file.children = existing.children
+	} else if name != "." {
+		parent := fsys.files[path.Dir(name)]
+		parent.children = append(parent.children, file.name)
+	}
+	fsys.files[name] = file
+}
+
+func (fsys *memFS) mkdirAll(name string) {
+	if _, ok := fsys.files[name]; ok {
+		return
+	}
+	if name != "." {
+		fsys.mkdirAll(path.Dir(name))
+	}
+	fsys.add(name, &memFile{name: path.Base(name), mode: fs.ModeDir | 0755})
+}
+
+func (fsys *memFS) lookup(op, name string) (*memFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	file, ok := fsys.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return file, nil
+}
+
+func (fsys *memFS) Open(name string) (fs.File, error) {
+	file, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memHandle{fsys: fsys, path: name, file: file, Reader: bytes.NewReader(file.data)}, nil
+}
+
+func (fsys *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !file.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	dir := name
+	if dir == "." {
+		dir = ""
+	}
+	entries := make([]fs.DirEntry, len(file.children))
+	for i, child := range file.children {
+		entries[i] = fs.FileInfoToDirEntry(fsys.files[joinPath(dir, child)])
+	}
+	return entries, nil
+}
+
+func (fsys *memFS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.lookup("stat", name)
+}
+
+func (fsys *memFS) Lstat(name string) (fs.FileInfo, error) {
+	return fsys.lookup("lstat", name)
+}
+
+func (fsys *memFS) ReadLink(name string) (string, error) {
+	file, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if file.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return file.target, nil
+}
+
+// memHandle is an open memFile.
+type memHandle struct {
+	fsys *memFS
+	path string
+	file *memFile
+	*bytes.Reader
+	read int
+}
+
+func (h *memHandle) Stat() (fs.FileInfo, error) { return h.file, nil }
+func (h *memHandle) Close() error               { return nil }
+
+func (h *memHandle) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := h.fsys.ReadDir(h.path)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[h.read:]
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	h.read += len(entries)
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+// Package tree prints the contents of a file system as a tree, like the unix
+// tree utility does. It works on any fs.FS: directories on disk, zip and tar
+// archives, embedded and in-memory file systems.
+package tree
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// Options select what is printed and how.
+type Options struct {
+	PrintFiles bool
+	Include    []string // show only files matching one of the globs
+	Exclude    []string // hide entries matching one of the globs
+	Gitignore  bool     // hide entries ignored by .gitignore files
+	Prune      bool     // hide directories left empty after filtering
+	MaxDepth   int      // levels to descend, 0 means no limit
+	DU         bool     // cumulative directory sizes and a summary footer
+	Human      bool
+	Follow     bool // descend into symlinked directories
+	Jobs       int  // directories read in parallel
+	SortBy     []string
+
+	// columns shown before the names
+	Perms      bool
+	Owner      bool
+	Group      bool
+	MTime      bool
+	Hash       bool
+	TimeFormat string
+}
+
+// Render writes the tree of fsys in format, which is one of ascii, json or
+// html. Errors met below the root do not stop it, they are marked inline
+// and returned together once the tree is written.
+func Render(output io.Writer, fsys fs.FS, opts Options, format string) error {
+	r, err := newRenderer(format, output, opts)
+	if err != nil {
+		return err
+	}
+
+	w, err := newWalker(fsys, opts, r)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+
+	if err := r.begin(); err != nil {
+		return err
+	}
+	if err := w.walk(); err != nil {
+		return fmt.Errorf("error in printing dir: %v", err)
+	}
+
+	var summary *stats
+	if opts.DU {
+		w.stats.size = w.du("", nil)
+		summary = &w.stats
+	}
+	if err := r.end(summary); err != nil {
+		return err
+	}
+
+	if len(w.errs) > 0 {
+		return w.errs
+	}
+	return nil
+}
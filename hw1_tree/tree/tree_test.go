@@ -0,0 +1,754 @@
+package tree
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const testJSONResult = `[{"name":"empty.txt","type":"file","size":0},{"name":"lorem","type":"directory","children":[{"name":"dolor.txt","type":"file","size":0},{"name":"gopher.png","type":"file","size":70372},{"name":"ipsum","type":"directory","children":[{"name":"gopher.png","type":"file","size":70372}]}]}]
+`
+
+func TestTreeJSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := renderDir(out, "../testdata/zline", Options{PrintFiles: true}, "json")
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	result := out.String()
+	if result != testJSONResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testJSONResult)
+	}
+}
+
+const testHTMLResult = `<ul>
+	<li>empty.txt (empty)</li>
+	<li>lorem
+		<ul>
+			<li>dolor.txt (empty)</li>
+			<li>gopher.png (70372b)</li>
+			<li>ipsum
+				<ul>
+					<li>gopher.png (70372b)</li>
+				</ul>
+			</li>
+		</ul>
+	</li>
+</ul>
+`
+
+func TestTreeHTML(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := renderDir(out, "../testdata/zline", Options{PrintFiles: true}, "html")
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	result := out.String()
+	if result != testHTMLResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testHTMLResult)
+	}
+}
+
+func TestTreeUnknownFormat(t *testing.T) {
+	err := renderDir(new(bytes.Buffer), "../testdata", Options{PrintFiles: true}, "xml")
+	if err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+// makeTree creates files (slash separated path -> content) in a temporary
+// directory and returns its path. Names ending with a slash are created as
+// empty directories.
+func makeTree(t testing.TB, files map[string]string) string {
+	root, err := ioutil.TempDir("", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// renderDir renders the directory at path on disk.
+func renderDir(output io.Writer, path string, opts Options, format string) error {
+	return Render(output, os.DirFS(path), opts, format)
+}
+
+func checkTree(t *testing.T, path string, opts Options, expected string) {
+	checkFS(t, os.DirFS(path), opts, expected)
+}
+
+func checkFS(t *testing.T, fsys fs.FS, opts Options, expected string) {
+	out := new(bytes.Buffer)
+	err := Render(out, fsys, opts, "ascii")
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	result := out.String()
+	if result != expected {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+const testIncludeResult = `├───a_lorem
+│	├───gopher.png (70372b)
+│	└───ipsum
+│		└───gopher.png (70372b)
+└───z_lorem
+	├───gopher.png (70372b)
+	└───ipsum
+		└───gopher.png (70372b)
+`
+
+func TestTreeIncludePrune(t *testing.T) {
+	opts := Options{PrintFiles: true, Include: []string{"*.png"}, Prune: true}
+	checkTree(t, "../testdata/static", opts, testIncludeResult)
+}
+
+// countingFS counts the directory reads by name.
+type countingFS struct {
+	fstest.MapFS
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (c *countingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.mu.Lock()
+	c.reads[name]++
+	c.mu.Unlock()
+	return c.MapFS.ReadDir(name)
+}
+
+func TestTreePruneReadsOnce(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"a/b/c/d/e/f/g/gopher.png": {Data: []byte("png")},
+		"a/b/c/d/e/f/g/notes.txt":  {},
+		"a/b/x/notes.txt":          {},
+	}, reads: map[string]int{}}
+	opts := Options{PrintFiles: true, Include: []string{"*.png"}, Prune: true}
+	checkFS(t, fsys, opts, "└───a\n\t└───b\n\t\t└───c\n\t\t\t└───d\n\t\t\t\t└───e\n\t\t\t\t\t└───f\n\t\t\t\t\t\t└───g\n\t\t\t\t\t\t\t└───gopher.png (3b)\n")
+
+	// once checking for entries and once rendering
+	for name, n := range fsys.reads {
+		if n > 2 {
+			t.Errorf("%s read %d times", name, n)
+		}
+	}
+}
+
+const testExcludeResult = `└───lorem
+	└───gopher.png (70372b)
+`
+
+func TestTreeExclude(t *testing.T) {
+	opts := Options{PrintFiles: true, Exclude: []string{"ipsum", "*.txt"}}
+	checkTree(t, "../testdata/zline", opts, testExcludeResult)
+}
+
+const testGitignoreResult = `├───.gitignore (13b)
+├───keep.txt (4b)
+└───sub
+	├───.gitignore (26b)
+	├───deep
+	│	└───local.txt (5b)
+	└───important.log (9b)
+`
+
+func TestTreeGitignore(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		".gitignore":         "*.log\nbuild/\n",
+		"a.log":              "log",
+		"keep.txt":           "keep",
+		"build/out.txt":      "out",
+		"sub/.gitignore":     "!important.log\n/local.txt\n",
+		"sub/important.log":  "important",
+		"sub/other.log":      "other",
+		"sub/local.txt":      "local",
+		"sub/deep/local.txt": "local",
+	})
+	defer os.RemoveAll(root)
+
+	checkTree(t, root, Options{PrintFiles: true, Gitignore: true}, testGitignoreResult)
+}
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "a/b.txt", false},
+		{"**/b.txt", "b.txt", true},
+		{"**/b.txt", "a/c/b.txt", true},
+		{"a/**", "a/c/b.txt", true},
+		{"a/**/b.txt", "a/b.txt", true},
+		{"a/*/b.txt", "a/b.txt", false},
+	}
+	for _, c := range cases {
+		if got := matchPath(c.pattern, c.name); got != c.match {
+			t.Errorf("matchPath(%q, %q) = %v, expected %v", c.pattern, c.name, got, c.match)
+		}
+	}
+}
+
+const testDepthResult = `├───project
+├───static
+│	├───a_lorem …
+│	├───css
+│	├───html
+│	├───js
+│	└───z_lorem …
+└───zline
+	└───lorem …
+`
+
+func TestTreeDepth(t *testing.T) {
+	checkTree(t, "../testdata", Options{MaxDepth: 2}, testDepthResult)
+}
+
+// benchTree builds a tree with fanout directories and files on every level.
+func benchTree(b *testing.B, levels, fanout int) string {
+	files := map[string]string{}
+	var fill func(dir string, level int)
+	fill = func(dir string, level int) {
+		for i := 0; i < fanout; i++ {
+			files[fmt.Sprintf("%sfile%d.txt", dir, i)] = strings.Repeat("x", i)
+			if level < levels {
+				fill(fmt.Sprintf("%sdir%d/", dir, i), level+1)
+			}
+		}
+	}
+	fill("", 1)
+	return makeTree(b, files)
+}
+
+func BenchmarkDirTree(b *testing.B) {
+	root := benchTree(b, 4, 5)
+	defer os.RemoveAll(root)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		renderDir(ioutil.Discard, root, Options{PrintFiles: true}, "ascii")
+	}
+}
+
+// BenchmarkDirTreeBuffered runs the former implementation, bufferedDirTree,
+// which kept the whole output in a strings.Builder and copied it to a
+// bytes.Buffer at the end.
+func BenchmarkDirTreeBuffered(b *testing.B) {
+	root := benchTree(b, 4, 5)
+	defer os.RemoveAll(root)
+
+	streamed, buffered := new(bytes.Buffer), new(bytes.Buffer)
+	renderDir(streamed, root, Options{PrintFiles: true}, "ascii")
+	if err := bufferedDirTree(buffered, root, true); err != nil || buffered.String() != streamed.String() {
+		b.Fatalf("former implementation renders differently (%v)", err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		bufferedDirTree(new(bytes.Buffer), root, true)
+	}
+}
+
+// bufferedDirTree and the functions below are dirTree as it was before the
+// output was streamed, kept to compare against.
+func bufferedDirTree(output *bytes.Buffer, path string, printFiles bool) error {
+	var dirTree strings.Builder
+	printDirErr := bufferedPrintDir("", path, &dirTree, printFiles)
+	if printDirErr != nil {
+		return fmt.Errorf("error in printing dir: %v", printDirErr.Error())
+	}
+
+	output.WriteString(dirTree.String())
+
+	return nil
+}
+
+func bufferedPrintDir(prefix string, path string, result *strings.Builder, printFiles bool) error {
+	list, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("%s", err.Error())
+	}
+
+	if !printFiles {
+		list = bufferedFilterFiles(list)
+	}
+
+	for pos, item := range list {
+		isLast := pos == len(list)-1
+		itemPrefix := "├───"
+		nextLevelPrefix := "│\t"
+		if isLast {
+			itemPrefix = "└───"
+			nextLevelPrefix = "\t"
+		}
+
+		if item.IsDir() {
+			result.WriteString(fmt.Sprintf("%s%s%s\n", prefix, itemPrefix, item.Name()))
+			bufferedPrintDir(prefix+nextLevelPrefix, path+"/"+item.Name(), result, printFiles)
+		} else {
+			bufferedPrintFile(prefix+itemPrefix, item, result)
+		}
+	}
+
+	return nil
+}
+
+func bufferedPrintFile(prefix string, file os.FileInfo, result *strings.Builder) {
+	result.WriteString(fmt.Sprintf("%s%s (%s)\n", prefix, file.Name(), bufferedFormatSize(file.Size())))
+}
+
+func bufferedFormatSize(size int64) string {
+	if size > 0 {
+		return fmt.Sprintf("%db", size)
+	}
+
+	return "empty"
+}
+
+func bufferedFilterFiles(items []os.FileInfo) (result []os.FileInfo) {
+	for _, item := range items {
+		if item.IsDir() {
+			result = append(result, item)
+		}
+	}
+	return
+}
+
+const testDuResult = `├───empty.txt (empty)
+└───lorem (140744b)
+	├───dolor.txt (empty)
+	├───gopher.png (70372b)
+	└───ipsum (70372b)
+		└───gopher.png (70372b)
+
+2 directories, 4 files, 140744 bytes
+`
+
+func TestTreeDu(t *testing.T) {
+	checkTree(t, "../testdata/zline", Options{PrintFiles: true, DU: true}, testDuResult)
+}
+
+const testDuHumanResult = `└───lorem (137.4KiB)
+	└───ipsum (68.7KiB)
+
+2 directories, 0 files, 137.4KiB
+`
+
+func TestTreeDuHuman(t *testing.T) {
+	checkTree(t, "../testdata/zline", Options{DU: true, Human: true}, testDuHumanResult)
+}
+
+func TestFormatSize(t *testing.T) {
+	cases := []struct {
+		size     int64
+		human    bool
+		expected string
+	}{
+		{0, true, "empty"},
+		{1023, true, "1023b"},
+		{70372, false, "70372b"},
+		{70372, true, "68.7KiB"},
+		{5 << 30, true, "5.0GiB"},
+	}
+	for _, c := range cases {
+		if got := formatSize(c.size, c.human); got != c.expected {
+			t.Errorf("formatSize(%d, %v) = %q, expected %q", c.size, c.human, got, c.expected)
+		}
+	}
+}
+
+const testSymlinkResult = `├───a
+│	├───b
+│	│	└───up -> .. [recursive, not followed]
+│	├───f (3b)
+│	└───flink -> f
+├───alink -> a
+│	├───b
+│	│	└───up -> .. [recursive, not followed]
+│	├───f (3b)
+│	└───flink -> f
+└───broken -> missing
+`
+
+func TestTreeSymlinks(t *testing.T) {
+	root := makeTree(t, map[string]string{"a/f": "abc", "a/b/": ""})
+	defer os.RemoveAll(root)
+
+	links := map[string]string{"a/b/up": "..", "a/flink": "f", "alink": "a", "broken": "missing"}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	checkTree(t, root, Options{PrintFiles: true, Follow: true}, testSymlinkResult)
+}
+
+// brokenLinkFS fails to read its symlinks.
+type brokenLinkFS struct {
+	fstest.MapFS
+}
+
+func (brokenLinkFS) ReadLink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrPermission}
+}
+
+func (b brokenLinkFS) Lstat(name string) (fs.FileInfo, error) {
+	return b.MapFS.Lstat(name)
+}
+
+func TestTreeDULinks(t *testing.T) {
+	fsys := &countingFS{MapFS: fstest.MapFS{
+		"d/f.txt": {Data: []byte("abc")},
+		"link":    {Data: []byte("d"), Mode: fs.ModeSymlink},
+	}, reads: map[string]int{}}
+	opts := Options{PrintFiles: true, DU: true}
+	out := new(bytes.Buffer)
+	if err := Render(out, fsys, opts, "ascii"); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.reads["link"] != 0 {
+		t.Errorf("the linked directory was read %d times without -l:\n%s", fsys.reads["link"], out)
+	}
+
+	opts.Follow = true
+	out.Reset()
+	if err := Render(out, fsys, opts, "ascii"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "link -> d (3b)") {
+		t.Errorf("expected the size of the followed link:\n%s", out)
+	}
+}
+
+func TestTreeErrorsOnce(t *testing.T) {
+	fsys := brokenLinkFS{fstest.MapFS{
+		"d/f.txt": {Data: []byte("abc")},
+		"d/link":  {Data: []byte("f.txt"), Mode: fs.ModeSymlink},
+	}}
+	// the directory is read to prune, to sum its size and to render it
+	opts := Options{PrintFiles: true, Prune: true, DU: true}
+	err := Render(new(bytes.Buffer), fsys, opts, "ascii")
+	errs, ok := err.(walkErrors)
+	if !ok || len(errs) != 1 {
+		t.Errorf("expected the readlink error once, got %v", err)
+	}
+}
+
+func TestTreeJSONEscaping(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{Perms: true, Owner: true, Group: true, MTime: true}
+	r, err := newRenderer("json", out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.begin()
+	weird := "a\x01b\xff"
+	r.entry(entry{name: "f", note: "open " + weird + ": error", owner: weird, group: weird, hash: weird}, true)
+	r.end(nil)
+	if !json.Valid(out.Bytes()) {
+		t.Errorf("invalid JSON: %s", out)
+	}
+}
+
+const testPermissionResult = `├───locked [permission denied]
+└───open
+`
+
+func TestTreePermissionDenied(t *testing.T) {
+	root := makeTree(t, map[string]string{"locked/x": "", "open/y": ""})
+	defer os.RemoveAll(root)
+	locked := filepath.Join(root, "locked")
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0755)
+	if _, err := ioutil.ReadDir(locked); err == nil {
+		t.Skip("permissions are not enforced for this user")
+	}
+
+	out := new(bytes.Buffer)
+	err := renderDir(out, root, Options{}, "ascii")
+	if err == nil {
+		t.Errorf("expected permission error")
+	}
+	if result := out.String(); result != testPermissionResult {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testPermissionResult)
+	}
+}
+
+func TestTreeParallel(t *testing.T) {
+	cases := []Options{
+		{PrintFiles: true},
+		{},
+		{MaxDepth: 2},
+		{PrintFiles: true, DU: true},
+	}
+	for _, opts := range cases {
+		expected := new(bytes.Buffer)
+		if err := renderDir(expected, "../testdata", opts, "ascii"); err != nil {
+			t.Fatal(err)
+		}
+		opts.Jobs = 4
+		checkTree(t, "../testdata", opts, expected.String())
+	}
+}
+
+func BenchmarkDirTreeParallel(b *testing.B) {
+	root := benchTree(b, 4, 5)
+	defer os.RemoveAll(root)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		renderDir(ioutil.Discard, root, Options{PrintFiles: true, Jobs: 8}, "ascii")
+	}
+}
+
+const testDiffResult = `├───[+] added
+│	└───[+] a.txt (1b)
+├───[-] gone.txt (4b)
+├───same
+│	├───[~] grown.txt (2b -> 5b)
+│	└───kept.txt (4b)
+└───[-] sub
+	└───[-] x.txt (1b)
+`
+
+func TestDiff(t *testing.T) {
+	before := fstest.MapFS{
+		"gone.txt":       {Data: []byte("gone")},
+		"same/kept.txt":  {Data: []byte("kept")},
+		"same/grown.txt": {Data: []byte("ab")},
+		"sub/x.txt":      {Data: []byte("x")},
+	}
+	after := fstest.MapFS{
+		"added/a.txt":    {Data: []byte("a")},
+		"same/kept.txt":  {Data: []byte("kept")},
+		"same/grown.txt": {Data: []byte("abcde")},
+	}
+	opts := Options{PrintFiles: true}
+
+	snapshot := new(bytes.Buffer)
+	if err := Render(snapshot, before, opts, "json"); err != nil {
+		t.Fatal(err)
+	}
+	fromDir, err := Load(before, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromSnapshot, err := LoadSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newNodes, err := Load(after, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, oldNodes := range [][]*Node{fromDir, fromSnapshot} {
+		out := new(bytes.Buffer)
+		changes, err := Diff(out, oldNodes, newNodes, opts, "ascii")
+		if err != nil {
+			t.Errorf("test for OK Failed - error: %v", err)
+		}
+		if changes != 6 {
+			t.Errorf("expected 6 changes, got %d", changes)
+		}
+		if result := out.String(); result != testDiffResult {
+			t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, testDiffResult)
+		}
+	}
+}
+
+func TestDiffSame(t *testing.T) {
+	opts := Options{PrintFiles: true}
+	nodes, err := Load(os.DirFS("../testdata"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Diff(new(bytes.Buffer), nodes, nodes, opts, "ascii")
+	if err != nil || changes != 0 {
+		t.Errorf("expected no changes, got %d (%v)", changes, err)
+	}
+}
+
+func TestDiffSnapshotLinks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"d/a":  {Data: []byte("abc")},
+		"link": {Data: []byte("d/a"), Mode: fs.ModeSymlink},
+	}
+	opts := Options{PrintFiles: true}
+
+	snapshot := new(bytes.Buffer)
+	if err := Render(snapshot, fsys, opts, "json"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := LoadSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Load(fsys, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if changes, err := Diff(out, before, after, opts, "ascii"); err != nil || changes != 0 {
+		t.Errorf("expected no changes, got %d (%v)\n%s", changes, err, out)
+	}
+}
+
+const testSortSizeResult = `├───a_lorem (140744b)
+│	├───gopher.png (70372b)
+│	├───ipsum (70372b)
+│	│	└───gopher.png (70372b)
+│	└───dolor.txt (empty)
+├───z_lorem (140744b)
+│	├───gopher.png (70372b)
+│	├───ipsum (70372b)
+│	│	└───gopher.png (70372b)
+│	└───dolor.txt (empty)
+├───html (57b)
+│	└───index.html (57b)
+├───css (28b)
+│	└───body.css (28b)
+├───js (10b)
+│	└───site.js (10b)
+└───empty.txt (empty)
+
+7 directories, 10 files, 281583 bytes
+`
+
+func TestTreeSortSize(t *testing.T) {
+	opts := Options{PrintFiles: true, DU: true, SortBy: []string{"size"}}
+	checkTree(t, "../testdata/static", opts, testSortSizeResult)
+}
+
+const testSortExtResult = `├───dir
+├───b.css (1b)
+├───a.go (1b)
+├───c.go (1b)
+└───a.txt (1b)
+`
+
+func TestTreeSortExt(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "1", "a.go": "1", "c.go": "1", "b.css": "1", "dir/": ""})
+	defer os.RemoveAll(root)
+
+	checkTree(t, root, Options{PrintFiles: true, SortBy: []string{"dirsfirst", "ext"}}, testSortExtResult)
+}
+
+func TestTreeSortUnknown(t *testing.T) {
+	err := renderDir(new(bytes.Buffer), "../testdata", Options{SortBy: []string{"color"}}, "ascii")
+	if err == nil {
+		t.Errorf("expected error for unknown sort key")
+	}
+}
+
+const testColumnsResult = `└───[-rw-r----- 2020-01-02 ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad] abc.txt (3b)
+`
+
+func TestTreeColumns(t *testing.T) {
+	root := makeTree(t, map[string]string{"abc.txt": "abc"})
+	defer os.RemoveAll(root)
+	file := filepath.Join(root, "abc.txt")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{PrintFiles: true, Perms: true, MTime: true, TimeFormat: "2006-01-02", Hash: true}
+	checkTree(t, root, opts, testColumnsResult)
+}
+
+const testArchiveResult = `├───empty.txt (empty)
+├───link -> lorem/gopher.png
+└───lorem
+	├───dolor.txt (empty)
+	├───gopher.png (70372b)
+	└───ipsum
+		└───gopher.png (70372b)
+`
+
+func TestTreeTar(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		var w io.Writer = buf
+		var gz *gzip.Writer
+		if compress {
+			gz = gzip.NewWriter(buf)
+			w = gz
+		}
+		archive := tar.NewWriter(w)
+		if err := archive.AddFS(os.DirFS("../testdata/zline")); err != nil {
+			t.Fatal(err)
+		}
+		hdr := &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "lorem/gopher.png"}
+		if err := archive.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		archive.Close()
+		if gz != nil {
+			gz.Close()
+		}
+
+		fsys, err := NewTarFS(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(fsys, "empty.txt", "lorem/ipsum/gopher.png"); err != nil {
+			t.Error(err)
+		}
+		checkFS(t, fsys, Options{PrintFiles: true, Follow: true}, testArchiveResult)
+	}
+}
+
+func TestTreeZip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	if err := archive.AddFS(os.DirFS("../testdata/zline")); err != nil {
+		t.Fatal(err)
+	}
+	hdr := &zip.FileHeader{Name: "link"}
+	hdr.SetMode(fs.ModeSymlink | 0777)
+	link, err := archive.CreateHeader(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(link, "lorem/gopher.png")
+	archive.Close()
+
+	fsys, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFS(t, fsys, Options{PrintFiles: true}, testArchiveResult)
+}
@@ -0,0 +1,373 @@
+package tree
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+type walker struct {
+	fsys  fs.FS
+	opts  Options
+	r     renderer
+	stats stats
+	// pool reads directories ahead of the walk, it is nil when directories
+	// are read one by one.
+	pool *readPool
+	mu   sync.Mutex
+	errs walkErrors
+	// errPaths are the paths with an error in errs, a directory is read
+	// more than once with -prune or -du but its errors are reported once.
+	errPaths map[string]bool
+	// sizes caches cumulative directory sizes by path.
+	sizes map[string]int64
+	// kept caches by path whether a directory has entries left after
+	// pruning, it is guarded by mu as the read pool prunes too.
+	kept map[string]bool
+	// visiting holds the directories on the current path when symlinks are
+	// followed, to detect cycles.
+	visiting map[fileID]bool
+	sort     func(list []entry)
+	names    idNames
+}
+
+func newWalker(fsys fs.FS, opts Options, r renderer) (*walker, error) {
+	sorter, err := newSorter(opts.SortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &walker{fsys: fsys, opts: opts, r: r, sort: sorter}
+	if opts.Jobs > 1 {
+		w.pool = newReadPool(w, opts.Jobs)
+	}
+	return w, nil
+}
+
+func (w *walker) close() {
+	if w.pool != nil {
+		w.pool.close()
+	}
+}
+
+// stats counts what was rendered, for the summary footer.
+type stats struct {
+	dirs  int
+	files int
+	size  int64
+}
+
+// walkErrors collects the errors met below the tree root. They do not stop
+// the walk, the affected entries are marked inline instead.
+type walkErrors []error
+
+func (errs walkErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// walk renders the root of the file system, whose entries are on the first
+// level.
+func (w *walker) walk() error {
+	list, rules, err := w.list("", nil)
+	if err != nil {
+		return err
+	}
+
+	w.visiting = map[fileID]bool{}
+	if w.opts.Follow {
+		if id, ok := statID(w.fsys, "."); ok {
+			w.visiting[id] = true
+		}
+	}
+	return w.walkList("", list, rules, 1)
+}
+
+// walkList renders list, the entries of the directory at path which are depth
+// levels below the root. rules are the .gitignore rules that apply to them.
+// The root itself has the empty path.
+func (w *walker) walkList(path string, list []entry, rules []ignoreRule, depth int) error {
+	for i, e := range list {
+		switch {
+		// linked directories are only summed when they are walked
+		case e.isDir && w.opts.DU && (e.target == "" || w.opts.Follow):
+			list[i].size = w.du(joinPath(path, e.name), rules)
+		case e.mode.IsRegular() && w.opts.Hash:
+			hash, err := hashFile(w.fsys, joinPath(path, e.name))
+			if err != nil {
+				w.addError(joinPath(path, e.name), err)
+				hash = "?"
+			}
+			list[i].hash = hash
+		}
+	}
+	w.sort(list)
+
+	listings := make([]*listing, len(list))
+	if w.pool != nil {
+		for pos, e := range list {
+			if e.isDir && (e.target == "" || w.opts.Follow) {
+				listings[pos] = w.pool.submit(joinPath(path, e.name), rules)
+			}
+		}
+	}
+
+	for pos, e := range list {
+		isLast := pos == len(list)-1
+		if !e.isDir {
+			w.stats.files++
+			if err := w.r.entry(e, isLast); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := w.walkDir(path, e, listings[pos], isLast, rules, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkDir renders the directory e and its children. pending is its listing
+// when it was submitted to the read pool.
+func (w *walker) walkDir(path string, e entry, pending *listing, isLast bool, rules []ignoreRule, depth int) error {
+	itemPath := joinPath(path, e.name)
+	w.stats.dirs++
+
+	descend := e.target == "" || w.opts.Follow
+	id, tracked := fileID{}, false
+	if descend && w.opts.Follow {
+		if id, tracked = statID(w.fsys, itemPath); tracked && w.visiting[id] {
+			e.note = "recursive, not followed"
+			descend = false
+		}
+	}
+
+	var children []entry
+	var childRules []ignoreRule
+	if descend {
+		var err error
+		if pending != nil {
+			children, childRules, err = pending.wait()
+		} else {
+			children, childRules, err = w.list(itemPath, rules)
+		}
+		if err != nil {
+			e.note = "error opening dir"
+			if errors.Is(err, fs.ErrPermission) {
+				e.note = "permission denied"
+			}
+			w.addError(itemPath, err)
+			descend = false
+		}
+	}
+	if descend && w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+		e.truncated = len(children) > 0
+		descend = false
+	}
+	if err := w.r.entry(e, isLast); err != nil {
+		return err
+	}
+	if descend {
+		if tracked {
+			w.visiting[id] = true
+			defer delete(w.visiting, id)
+		}
+		if err := w.walkList(itemPath, children, childRules, depth+1); err != nil {
+			return err
+		}
+	}
+	return w.r.leave()
+}
+
+// addError records err, the first error met at path.
+func (w *walker) addError(path string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.errPaths[path] {
+		return
+	}
+	if w.errPaths == nil {
+		w.errPaths = map[string]bool{}
+	}
+	w.errPaths[path] = true
+	w.errs = append(w.errs, err)
+}
+
+// du returns the cumulative size of the files below path that pass the
+// filters, whether or not they are printed. Symlinks are not followed.
+func (w *walker) du(path string, rules []ignoreRule) int64 {
+	if size, ok := w.sizes[path]; ok {
+		return size
+	}
+
+	var size int64
+	items, rules, err := w.read(path, rules)
+	if err != nil {
+		w.addError(path, err)
+	}
+	for _, e := range items {
+		switch {
+		case e.mode.IsDir():
+			size += w.du(joinPath(path, e.name), rules)
+		case e.mode.IsRegular():
+			size += e.size
+		}
+	}
+
+	if w.sizes == nil {
+		w.sizes = map[string]int64{}
+	}
+	w.sizes[path] = size
+	return size
+}
+
+// list returns the entries of the directory at path that should be printed.
+// It also returns the .gitignore rules that apply to its subdirectories.
+func (w *walker) list(path string, rules []ignoreRule) ([]entry, []ignoreRule, error) {
+	items, rules, err := w.read(path, rules)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := items[:0]
+	for _, e := range items {
+		if !e.isDir && !w.opts.PrintFiles {
+			continue
+		}
+		// symlinked directories are kept as is, looking into them could loop
+		if e.isDir && e.target == "" && w.opts.Prune && !w.keep(joinPath(path, e.name), rules) {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, rules, nil
+}
+
+// keep reports whether the directory at path is kept when pruning: it has
+// entries left, or it can't be read and the walk reports why. Each
+// directory is checked once, not once per ancestor.
+func (w *walker) keep(path string, rules []ignoreRule) bool {
+	w.mu.Lock()
+	kept, ok := w.kept[path]
+	w.mu.Unlock()
+	if ok {
+		return kept
+	}
+
+	children, _, err := w.list(path, rules)
+	kept = err != nil || len(children) > 0
+
+	w.mu.Lock()
+	if w.kept == nil {
+		w.kept = map[string]bool{}
+	}
+	w.kept[path] = kept
+	w.mu.Unlock()
+	return kept
+}
+
+// read reads the directory at path and drops the entries hidden by patterns
+// and .gitignore rules.
+func (w *walker) read(path string, rules []ignoreRule) ([]entry, []ignoreRule, error) {
+	items, err := fs.ReadDir(w.fsys, fsPath(path))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if w.opts.Gitignore {
+		local, err := readGitignore(w.fsys, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules[:len(rules):len(rules)], local...)
+	}
+
+	result := make([]entry, 0, len(items))
+	for _, item := range items {
+		info, err := item.Info()
+		if err != nil {
+			w.addError(joinPath(path, item.Name()), err)
+			continue
+		}
+		e := w.newEntry(path, info)
+		if w.matched(e, path, rules) {
+			result = append(result, e)
+		}
+	}
+	return result, rules, nil
+}
+
+// newEntry describes item, resolving it if it is a symlink.
+func (w *walker) newEntry(path string, item fs.FileInfo) entry {
+	e := entry{name: item.Name(), size: item.Size(), isDir: item.IsDir(), mode: item.Mode(), modTime: item.ModTime()}
+	if w.opts.Owner || w.opts.Group {
+		if uid, gid, ok := fileOwner(item); ok {
+			e.owner, e.group = w.names.user(uid), w.names.group(gid)
+		}
+	}
+	if item.Mode()&fs.ModeSymlink == 0 {
+		return e
+	}
+
+	itemPath := joinPath(path, item.Name())
+	target, err := readLink(w.fsys, itemPath)
+	if err != nil {
+		w.addError(itemPath, err)
+		target = "?"
+	}
+	e.target = target
+	if info, err := fs.Stat(w.fsys, itemPath); err == nil {
+		e.isDir = info.IsDir()
+	}
+	return e
+}
+
+// readLink returns the target of the symlink at path. File systems which
+// cannot read links, like zip archives, keep the target as the link content.
+func readLink(fsys fs.FS, path string) (string, error) {
+	target, err := fs.ReadLink(fsys, path)
+	if errors.Is(err, fs.ErrInvalid) {
+		data, err := fs.ReadFile(fsys, path)
+		return string(data), err
+	}
+	return target, err
+}
+
+func (w *walker) matched(e entry, path string, rules []ignoreRule) bool {
+	itemPath := joinPath(path, e.name)
+
+	if matchAny(w.opts.Exclude, e.name, itemPath) {
+		return false
+	}
+	if !e.isDir && len(w.opts.Include) > 0 && !matchAny(w.opts.Include, e.name, itemPath) {
+		return false
+	}
+	if w.opts.Gitignore && (e.name == ".git" || ignored(rules, itemPath, e.isDir)) {
+		return false
+	}
+	return true
+}
+
+// joinPath joins slash separated paths below the root, which is the empty
+// path.
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// fsPath converts a path below the root to an fs.FS name.
+func fsPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Checkpoint records the results of completed items in a file of JSON
+// lines, so that a restarted run can skip them. Results are written as
+// soon as they are known; a line cut short by a crash is dropped when the
+// file is opened again.
+type Checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]json.RawMessage
+}
+
+type checkpointRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// OpenCheckpoint opens the checkpoint at path, creating it if needed, and
+// loads the results recorded by earlier runs.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	c := &Checkpoint{done: map[string]json.RawMessage{}}
+	valid := 0
+	for valid < len(data) {
+		end := bytes.IndexByte(data[valid:], '\n')
+		if end < 0 {
+			// the last line was not finished
+			break
+		}
+		var record checkpointRecord
+		if err := json.Unmarshal(data[valid:valid+end], &record); err != nil {
+			return nil, fmt.Errorf("checkpoint %s: %v", path, err)
+		}
+		c.done[record.Key] = record.Value
+		valid += end + 1
+	}
+
+	c.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.file.Truncate(int64(valid)); err != nil {
+		c.file.Close()
+		return nil, err
+	}
+	if _, err := c.file.Seek(int64(valid), 0); err != nil {
+		c.file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Len returns the number of results recorded.
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+func (c *Checkpoint) Close() error {
+	return c.file.Close()
+}
+
+func (c *Checkpoint) load(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.done[key]
+	return value, ok
+}
+
+func (c *Checkpoint) save(key string, value json.RawMessage) error {
+	line, err := json.Marshal(checkpointRecord{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.done[key]; ok {
+		return nil
+	}
+	if _, err := c.file.Write(line); err != nil {
+		return err
+	}
+	c.done[key] = value
+	return nil
+}
+
+// Checkpointed returns f recording its results in c under name, which
+// tells the functions sharing c apart. Items with a recorded result are
+// not passed to f again. Items and results must encode to JSON; a nil
+// checkpoint returns f as is.
+func Checkpointed[In, Out any](c *Checkpoint, name string, f func(ctx context.Context, data In) (Out, error)) func(ctx context.Context, data In) (Out, error) {
+	if c == nil {
+		return f
+	}
+	return func(ctx context.Context, data In) (Out, error) {
+		var result Out
+		item, err := json.Marshal(data)
+		if err != nil {
+			return result, err
+		}
+		key := name + "\x00" + string(item)
+
+		if value, ok := c.load(key); ok {
+			err := json.Unmarshal(value, &result)
+			return result, err
+		}
+
+		result, err = f(ctx, data)
+		if err != nil {
+			return result, err
+		}
+		value, err := json.Marshal(result)
+		if err != nil {
+			return result, err
+		}
+		return result, c.save(key, value)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// runCombined runs the items 0..9 through f and combines the results like
+// CombineResults does.
+func runCombined(f ItemFunc) (string, error) {
+	var results []string
+	err := NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 10; i++ {
+				out <- i
+			}
+		}), 1, 0).
+		StageFunc(f, 3, 0).
+		Stage(job(func(in, out chan interface{}) {
+			for data := range in {
+				results = append(results, data.(string))
+			}
+		}), 1, 0).
+		RunContext(context.Background())
+	sort.Strings(results)
+	return strings.Join(results, "_"), err
+}
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	var calls int32
+	square := func(ctx context.Context, data int) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return strconv.Itoa(data * data), nil
+	}
+	errCrash := errors.New("crash")
+	crashing := func(ctx context.Context, data int) (string, error) {
+		if data >= 5 {
+			return "", errCrash
+		}
+		return square(ctx, data)
+	}
+
+	expected, err := runCombined(func(ctx context.Context, data interface{}) (interface{}, error) {
+		return square(ctx, data.(int))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := Checkpointed(cp, "square", crashing)
+	_, err = runCombined(func(ctx context.Context, data interface{}) (interface{}, error) {
+		return first(ctx, data.(int))
+	})
+	if !errors.Is(err, errCrash) {
+		t.Fatalf("expected the first run to crash, got %v", err)
+	}
+	recorded := cp.Len()
+	cp.Close()
+	if recorded == 0 || recorded > 5 {
+		t.Fatalf("expected up to 5 recorded items, got %d", recorded)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	cp, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	second := Checkpointed(cp, "square", square)
+	result, err := runCombined(func(ctx context.Context, data interface{}) (interface{}, error) {
+		return second(ctx, data.(int))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	if int(calls) != 10-recorded {
+		t.Errorf("expected %d items computed again, got %d", 10-recorded, calls)
+	}
+}
+
+func TestCheckpointTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	content := `{"key":"a","value":"1"}` + "\n" + `{"key":"b","val`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Len() != 1 {
+		t.Errorf("expected 1 recorded result, got %d", cp.Len())
+	}
+	if err := cp.save("b", []byte(`"2"`)); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	cp, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if value, ok := cp.load("b"); cp.Len() != 2 || !ok || string(value) != `"2"` {
+		t.Errorf("expected a and b recorded, got %d results", cp.Len())
+	}
+}
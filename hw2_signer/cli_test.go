@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCLICombined(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "inputs.txt")
+	if err := os.WriteFile(file, []byte("1\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	opts := cliOptions{workers: 4, fanout: MultiHashFanout, format: "text"}
+	err := runSigner(context.Background(), opts, []string{"-", file}, strings.NewReader("0\n"), out, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out.String(), expected)
+	}
+}
+
+func TestCLIEachJSON(t *testing.T) {
+	t.Parallel()
+	out := new(bytes.Buffer)
+	opts := cliOptions{workers: 4, fanout: 2, format: "json", each: true}
+	err := runSigner(context.Background(), opts, nil, strings.NewReader("1\n0\n"), out, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the first 2 MultiHash hashes of the README example, in input order
+	expected := `{"input":"1","single":"2212294583~709660146","multi":"4958044192186797981"}
+{"input":"0","single":"4108050209~502633748","multi":"2956866606803518384"}
+`
+	if out.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out.String(), expected)
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	opts := cliOptions{workers: 1, fanout: 1, format: "xml"}
+	if err := runSigner(context.Background(), opts, nil, strings.NewReader(""), new(bytes.Buffer), nil); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	opts.format = "text"
+	for _, bad := range []cliOptions{{workers: 0, fanout: 1}, {workers: 1, fanout: 0}, {workers: -1, fanout: -2}} {
+		bad.format = "text"
+		out := new(bytes.Buffer)
+		if err := runSigner(context.Background(), bad, nil, strings.NewReader("1\n"), out, nil); err == nil || out.Len() > 0 {
+			t.Errorf("-j %d -fanout %d: expected an error and no output, got %v and %q", bad.workers, bad.fanout, err, out)
+		}
+	}
+	if err := runSigner(context.Background(), opts, []string{"missing.txt"}, nil, new(bytes.Buffer), nil); !os.IsNotExist(errors.Unwrap(err)) {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+}
+
+func TestCLIDeadLetters(t *testing.T) {
+	defer func(crc32, md5 func(string) string) {
+		DataSignerCrc32, DataSignerMd5 = crc32, md5
+	}(DataSignerCrc32, DataSignerMd5)
+
+	// "bad" always fails, "flaky" only on its first attempt
+	var mu sync.Mutex
+	flaky := 0
+	DataSignerCrc32 = func(data string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case data == "bad":
+			panic("crc32 of bad")
+		case data == "flaky" && flaky == 0:
+			flaky++
+			panic("crc32 of flaky")
+		}
+		return "c" + data
+	}
+	DataSignerMd5 = func(data string) string { return "m" + data }
+
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	out := new(bytes.Buffer)
+	opts := cliOptions{workers: 2, fanout: 2, format: "text", each: true, retry: RetryPolicy{MaxAttempts: 2}, deadLetters: deadLetters}
+	err := runSigner(context.Background(), opts, nil, strings.NewReader("good\nbad\nflaky\n"), out, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 inputs failed") {
+		t.Errorf("expected 1 failed input, got %v", err)
+	}
+	expected := "good\tc0cgood~cmgoodc1cgood~cmgood\nflaky\tc0cflaky~cmflakyc1cflaky~cmflaky\n"
+	if out.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", out.String(), expected)
+	}
+
+	data, err := os.ReadFile(deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letter deadInput
+	if err := json.Unmarshal(data, &letter); err != nil {
+		t.Fatalf("bad dead letters %q: %v", data, err)
+	}
+	if letter.Input != "bad" || letter.Stage != "SingleHash" || letter.Attempts != 2 || !strings.Contains(letter.Error, "crc32 of bad") {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+}
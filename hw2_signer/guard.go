@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Md5Guard serialises DataSignerMd5 calls, which overheat when run
+// concurrently.
+var Md5Guard = NewGuard(1, 0, 0)
+
+// Guard limits the use of a throttled backend: how many calls run at once
+// and how many start per second. Callers over the limits wait in a queue
+// and are let through in arrival order.
+type Guard struct {
+	mu       sync.Mutex
+	limit    int     // concurrent calls, 0 means no limit
+	rate     float64 // calls started per second, 0 means no limit
+	burst    float64
+	active   int
+	tokens   float64
+	refilled time.Time
+	queue    []*guardWaiter
+	timer    *time.Timer
+	stats    GuardStats
+}
+
+type guardWaiter struct {
+	ready   chan struct{}
+	granted bool
+	since   time.Time
+}
+
+// GuardStats are counters of a Guard.
+type GuardStats struct {
+	Calls     int64         // calls let through
+	Active    int           // calls running now
+	Waiting   int           // callers queued now
+	TotalWait time.Duration // time spent in the queue by all calls
+	MaxWait   time.Duration
+}
+
+// NewGuard returns a guard letting through up to limit concurrent calls and
+// rate calls per second, with bursts of up to burst calls. Zero disables a
+// limit.
+func NewGuard(limit int, rate float64, burst int) *Guard {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Guard{
+		limit:    limit,
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		refilled: time.Now(),
+	}
+}
+
+// Acquire waits for a turn to call the backend, which must be followed by
+// Release. It returns the error of ctx if ctx is done first.
+func (g *Guard) Acquire(ctx context.Context) error {
+	g.mu.Lock()
+	w := &guardWaiter{ready: make(chan struct{}), since: time.Now()}
+	g.queue = append(g.queue, w)
+	g.dispatch()
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if w.granted {
+		// let through while giving up, pass the turn on
+		g.active--
+		g.dispatch()
+		return ctx.Err()
+	}
+	for i, queued := range g.queue {
+		if queued == w {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			break
+		}
+	}
+	g.dispatch()
+	return ctx.Err()
+}
+
+// Release ends a call started with Acquire.
+func (g *Guard) Release() {
+	g.mu.Lock()
+	g.active--
+	g.dispatch()
+	g.mu.Unlock()
+}
+
+// Do calls f once the guard lets it through.
+func (g *Guard) Do(ctx context.Context, f func()) error {
+	if err := g.Acquire(ctx); err != nil {
+		return err
+	}
+	defer g.Release()
+	f()
+	return nil
+}
+
+// Stats returns a snapshot of the counters of g.
+func (g *Guard) Stats() GuardStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := g.stats
+	stats.Active = g.active
+	stats.Waiting = len(g.queue)
+	return stats
+}
+
+// dispatch lets through the callers at the head of the queue while the
+// limits allow. When only the rate holds them back it sets a timer for the
+// next token. g.mu must be held.
+func (g *Guard) dispatch() {
+	now := time.Now()
+	if g.rate > 0 {
+		g.tokens += now.Sub(g.refilled).Seconds() * g.rate
+		if g.tokens > g.burst {
+			g.tokens = g.burst
+		}
+		g.refilled = now
+	}
+
+	for len(g.queue) > 0 {
+		if g.limit > 0 && g.active >= g.limit {
+			return
+		}
+		if g.rate > 0 && g.tokens < 1 {
+			if g.timer == nil {
+				wait := time.Duration((1 - g.tokens) / g.rate * float64(time.Second))
+				g.timer = time.AfterFunc(wait, func() {
+					g.mu.Lock()
+					g.timer = nil
+					g.dispatch()
+					g.mu.Unlock()
+				})
+			}
+			return
+		}
+
+		w := g.queue[0]
+		g.queue = g.queue[1:]
+		if g.rate > 0 {
+			g.tokens--
+		}
+		g.active++
+		w.granted = true
+		close(w.ready)
+
+		wait := now.Sub(w.since)
+		g.stats.Calls++
+		g.stats.TotalWait += wait
+		if wait > g.stats.MaxWait {
+			g.stats.MaxWait = wait
+		}
+	}
+}
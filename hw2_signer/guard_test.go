@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGuardLimit(t *testing.T) {
+	g := NewGuard(2, 0, 0)
+	var running, maxRunning int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Do(context.Background(), func() {
+				now := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			})
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning != 2 {
+		t.Errorf("expected 2 calls at once, got %d", maxRunning)
+	}
+	stats := g.Stats()
+	if stats.Calls != 10 || stats.Active != 0 || stats.Waiting != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.MaxWait < 10*time.Millisecond || stats.TotalWait < stats.MaxWait {
+		t.Errorf("wait times not recorded: %+v", stats)
+	}
+}
+
+func TestGuardRate(t *testing.T) {
+	g := NewGuard(0, 100, 2)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		g.Do(context.Background(), func() {})
+	}
+	// 2 calls from the burst, 4 more at 10ms each
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond || elapsed > 200*time.Millisecond {
+		t.Errorf("expected about 40ms for 6 calls, took %s", elapsed)
+	}
+}
+
+func TestGuardFair(t *testing.T) {
+	g := NewGuard(1, 0, 0)
+	g.Acquire(context.Background())
+
+	order := make(chan int, 5)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(num int) {
+			defer wg.Done()
+			g.Do(context.Background(), func() { order <- num })
+		}(i)
+		for g.Stats().Waiting != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	g.Release()
+	wg.Wait()
+	close(order)
+
+	expected := 0
+	for num := range order {
+		if num != expected {
+			t.Fatalf("caller %d let through before caller %d", num, expected)
+		}
+		expected++
+	}
+}
+
+func TestGuardCancel(t *testing.T) {
+	g := NewGuard(1, 0, 0)
+	g.Acquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if stats := g.Stats(); stats.Waiting != 0 || stats.Active != 1 {
+		t.Errorf("cancelled caller still counted: %+v", stats)
+	}
+
+	g.Release()
+	if err := g.Do(context.Background(), func() {}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,28 +1,259 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// signed is the result for one input line.
+type signed struct {
+	Input  string `json:"input"`
+	Single string `json:"single"`
+	Multi  string `json:"multi"`
+}
+
+type cliOptions struct {
+	workers    int
+	fanout     int
+	format     string
+	each       bool
+	checkpoint string
+	window     Window
+	retry      RetryPolicy
+	// deadLetters is the file getting the inputs failing every attempt,
+	// without it they fail the run
+	deadLetters string
+}
+
+// deadInput is how an input failing every attempt is written to the dead
+// letters file.
+type deadInput struct {
+	Input    string `json:"input"`
+	Stage    string `json:"stage"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
 func main() {
-	inputData := []int{0, 1, 1, 2, 3, 5, 8}
+	opts := cliOptions{}
+	flag.IntVar(&opts.workers, "j", 16, "inputs hashed at once in each step")
+	flag.IntVar(&opts.fanout, "fanout", MultiHashFanout, "number of hashes MultiHash concatenates")
+	flag.StringVar(&opts.format, "format", "text", "output format: text or json")
+	flag.BoolVar(&opts.each, "each", false, "write the result of every input, in input order, instead of the combined one")
+	flag.StringVar(&opts.checkpoint, "checkpoint", "", "file recording the hashed inputs, a restarted run skips them")
+	flag.StringVar(&opts.deadLetters, "dead-letters", "", "file getting the inputs failing every attempt as JSON lines, instead of failing the run")
+	retries := flag.Int("retries", 0, "times a failed hash of an input is retried")
+	window := flag.String("window", "", "combine the results per window of `size[/slide]` inputs or `length[/slide]` time, like 100/50 or 10s")
+	salt := flag.String("salt", "", "salt added to the data before hashing")
+	cacheDir := flag.String("cache", "", "directory keeping the hashes across runs")
+	metricsAddr := flag.String("metrics", "", "address serving the pipeline metrics at /metrics")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: signer [flags] [file ...]\n\nSigns the lines of the files, or of stdin when there are none.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	opts.retry = RetryPolicy{MaxAttempts: *retries + 1, Backoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second, Jitter: 0.5}
+
+	var err error
+	if opts.window, err = ParseWindow(*window); err != nil {
+		log.Fatal(err)
+	}
+
+	DataSignerSalt = *salt
+	if *cacheDir != "" {
+		if Crc32Memo, err = OpenMemo(filepath.Join(*cacheDir, "crc32"), 0); err != nil {
+			log.Fatal(err)
+		}
+		if Md5Memo, err = OpenMemo(filepath.Join(*cacheDir, "md5"), 0); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var metrics *Metrics
+	if *metricsAddr != "" {
+		metrics = NewMetrics()
+		metrics.Publish("pipeline")
+		http.Handle("/metrics", metrics)
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = runSigner(context.Background(), opts, flag.Args(), os.Stdin, out, metrics)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// runSigner signs the lines of files, "-" or no files at all meaning stdin,
+// and writes the results to output.
+func runSigner(ctx context.Context, opts cliOptions, files []string, stdin io.Reader, output io.Writer, metrics *Metrics) error {
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown format %q", opts.format)
+	}
+	if opts.workers < 1 {
+		return fmt.Errorf("-j must be at least 1, got %d", opts.workers)
+	}
+	if opts.fanout < 1 {
+		return fmt.Errorf("-fanout must be at least 1, got %d", opts.fanout)
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
 
-	hashSignJobs := []job{
-		job(func(in, out chan interface{}) {
-			fmt.Println("Generator")
-			for _, fibNum := range inputData {
-				out <- fibNum
+	var cp *Checkpoint
+	if opts.checkpoint != "" {
+		var err error
+		if cp, err = OpenCheckpoint(opts.checkpoint); err != nil {
+			return err
+		}
+		defer cp.Close()
+	}
+
+	var dead chan DeadLetter
+	failed := 0
+	writeErr := make(chan error, 1)
+	if opts.deadLetters != "" {
+		file, err := os.Create(opts.deadLetters)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		dead = make(chan DeadLetter)
+		go func() {
+			var err error
+			enc := json.NewEncoder(file)
+			for letter := range dead {
+				failed++
+				input, ok := letter.Item.(string)
+				if !ok {
+					input = letter.Item.(signed).Input
+				}
+				if err == nil {
+					err = enc.Encode(deadInput{input, letter.Stage, letter.Attempts, letter.Err.Error()})
+				}
+			}
+			writeErr <- err
+		}()
+	}
+
+	read := func(ctx context.Context, in, out chan interface{}) error {
+		for _, name := range files {
+			if err := readLines(ctx, name, stdin, out); err != nil {
+				return err
 			}
-		}),
-		job(SingleHash),
-		job(MultiHash),
-		job(CombineResults),
-		job(func(in, out chan interface{}) {			
-			for dataRaw := range in {	
-				fmt.Println("Printer", dataRaw.(string))				
+		}
+		return nil
+	}
+	// results depend on the salt and fanout, a checkpoint keeps them apart
+	single := Checkpointed(cp, fmt.Sprintf("SingleHash salt=%q", DataSignerSalt), func(ctx context.Context, input string) (signed, error) {
+		hash, err := trySingleHash(input)
+		return signed{Input: input, Single: hash}, err
+	})
+	multi := Checkpointed(cp, fmt.Sprintf("MultiHash salt=%q fanout=%d", DataSignerSalt, opts.fanout), func(ctx context.Context, result signed) (signed, error) {
+		var err error
+		result.Multi, err = tryMultiHash(result.Single, opts.fanout)
+		return result, err
+	})
+	writeEach := func(ctx context.Context, in, out chan interface{}) error {
+		for data := range in {
+			result := data.(signed)
+			if err := writeResult(output, opts.format, result, result.Input+"\t"+result.Multi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	writeCombined := func(ctx context.Context, in, out chan interface{}) error {
+		for data := range in {
+			result := data.(string)
+			if err := writeResult(output, opts.format, map[string]string{"result": result}, result); err != nil {
+				return err
 			}
-		}),
+		}
+		return nil
 	}
 
-	ExecutePipeline(hashSignJobs...)
-}
\ No newline at end of file
+	p := NewPipeline().
+		Observe(metrics).
+		StageContext(read, 1, opts.workers).Name("read").
+		StageFunc(func(ctx context.Context, data interface{}) (interface{}, error) {
+			return single(ctx, data.(string))
+		}, opts.workers, 0).Name("SingleHash").Retry(opts.retry).
+		StageFunc(func(ctx context.Context, data interface{}) (interface{}, error) {
+			return multi(ctx, data.(signed))
+		}, opts.workers, 0).Name("MultiHash").Retry(opts.retry)
+	if dead != nil {
+		p.DeadLetters(dead)
+	}
+	if opts.each {
+		p.StageContext(writeEach, 1, 0).Name("write")
+	} else {
+		p.Stage(Map(func(data interface{}) interface{} { return data.(signed).Multi }), 1, 0).Name("result").
+			Stage(CombineWindowed(opts.window), 1, 0).Name("CombineResults").
+			StageContext(writeCombined, 1, 0).Name("write")
+	}
+	err := p.RunContext(ctx)
+	if dead == nil {
+		return err
+	}
+	close(dead)
+	if werr := <-writeErr; err == nil {
+		err = werr
+	}
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d inputs failed, see %s", failed, opts.deadLetters)
+	}
+	return err
+}
+
+// readLines sends the non blank lines of the file name, or of stdin for
+// "-", to out.
+func readLines(ctx context.Context, name string, stdin io.Reader, out chan interface{}) error {
+	r := stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := send(ctx, out, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// writeResult writes value as a JSON line or text as a plain line.
+func writeResult(w io.Writer, format string, value interface{}, text string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(value)
+	}
+	_, err := fmt.Fprintln(w, text)
+	return err
+}
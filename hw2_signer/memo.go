@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Crc32Memo and Md5Memo cache DataSignerCrc32 and DataSignerMd5 results
+// when set.
+var Crc32Memo, Md5Memo *Memo
+
+// Memo caches the results of a signer function keyed by data and
+// DataSignerSalt. It keeps the most recently used results in memory and,
+// if opened with a directory, all of them on disk. Identical calls made
+// while one is running wait for its result instead of computing it again.
+// A Memo must only be used for one function.
+type Memo struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List // of *memoEntry, most recently used first
+	entries map[string]*list.Element
+	calls   map[string]*memoCall
+	dir     string
+	stats   MemoStats
+}
+
+type memoEntry struct {
+	key   string
+	value string
+}
+
+// memoCall is a call in flight, done is closed once value is set. ok is
+// false if the call panicked.
+type memoCall struct {
+	done  chan struct{}
+	value string
+	ok    bool
+}
+
+// MemoStats are counters of a Memo.
+type MemoStats struct {
+	Hits     int64 // results found in memory
+	DiskHits int64 // results found on disk
+	Misses   int64 // results computed
+	Shared   int64 // calls which waited for an identical one in flight
+	Errors   int64 // failed disk reads and writes
+}
+
+// NewMemo returns a memo keeping up to size results in memory, size 0
+// means no limit.
+func NewMemo(size int) *Memo {
+	return &Memo{
+		size:    size,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		calls:   map[string]*memoCall{},
+	}
+}
+
+// OpenMemo returns a memo which also stores its results in dir, so they
+// are kept across runs.
+func OpenMemo(dir string, size int) (*Memo, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m := NewMemo(size)
+	m.dir = dir
+	return m, nil
+}
+
+// Do returns f(data), from the cache if it is there. A nil memo always
+// calls f.
+func (m *Memo) Do(data string, f func(string) string) string {
+	if m == nil {
+		return f(data)
+	}
+	key := data + "\x00" + DataSignerSalt
+
+	m.mu.Lock()
+	if e, ok := m.entries[key]; ok {
+		m.lru.MoveToFront(e)
+		m.stats.Hits++
+		m.mu.Unlock()
+		return e.Value.(*memoEntry).value
+	}
+	if c, ok := m.calls[key]; ok {
+		m.stats.Shared++
+		m.mu.Unlock()
+		<-c.done
+		if c.ok {
+			return c.value
+		}
+		return f(data)
+	}
+	c := &memoCall{done: make(chan struct{})}
+	m.calls[key] = c
+	m.mu.Unlock()
+
+	var fromDisk bool
+	var diskErr error
+	defer func() {
+		m.mu.Lock()
+		delete(m.calls, key)
+		switch {
+		case !c.ok:
+		case fromDisk:
+			m.stats.DiskHits++
+			m.add(key, c.value)
+		default:
+			m.stats.Misses++
+			m.add(key, c.value)
+		}
+		if diskErr != nil {
+			m.stats.Errors++
+		}
+		m.mu.Unlock()
+		close(c.done)
+	}()
+
+	if c.value, fromDisk, diskErr = m.load(key); !fromDisk {
+		c.value = f(data)
+		diskErr = m.save(key, c.value)
+	}
+	c.ok = true
+	return c.value
+}
+
+// Stats returns a snapshot of the counters of m.
+func (m *Memo) Stats() MemoStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// add puts a result in memory, evicting the least recently used one when
+// full. m.mu must be held.
+func (m *Memo) add(key, value string) {
+	m.entries[key] = m.lru.PushFront(&memoEntry{key: key, value: value})
+	if m.size > 0 && m.lru.Len() > m.size {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoEntry).key)
+	}
+}
+
+// path returns the file holding the result for key, named by its hash.
+func (m *Memo) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(m.dir, hex.EncodeToString(sum[:]))
+}
+
+func (m *Memo) load(key string) (string, bool, error) {
+	if m.dir == "" {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(m.path(key))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// save writes the result for key to a temporary file renamed into place,
+// so that an interrupted run leaves no partial results.
+func (m *Memo) save(key, value string) error {
+	if m.dir == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(m.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path(key))
+}
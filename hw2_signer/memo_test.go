@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoLRU(t *testing.T) {
+	m := NewMemo(2)
+	calls := 0
+	upper := func(data string) string {
+		calls++
+		return strings.ToUpper(data)
+	}
+
+	for _, data := range []string{"a", "b", "a", "c", "b", "a"} {
+		if got := m.Do(data, upper); got != strings.ToUpper(data) {
+			t.Errorf("Do(%q) = %q", data, got)
+		}
+	}
+	// a, b computed; a hit; c evicts b; b evicts a; a evicts c
+	stats := m.Stats()
+	if calls != 5 || stats.Misses != 5 || stats.Hits != 1 {
+		t.Errorf("expected 5 misses and 1 hit, got %d calls and %+v", calls, stats)
+	}
+}
+
+func TestMemoSingleflight(t *testing.T) {
+	m := NewMemo(0)
+	var calls int32
+	slow := func(data string) string {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return data + "!"
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := m.Do("x", slow); got != "x!" {
+				t.Errorf("Do(x) = %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := m.Stats()
+	if calls != 1 || stats.Misses != 1 || stats.Hits+stats.Shared != 9 {
+		t.Errorf("expected a single call, got %d calls and %+v", calls, stats)
+	}
+}
+
+func TestMemoDisk(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	upper := func(data string) string {
+		calls++
+		return strings.ToUpper(data)
+	}
+
+	m, err := OpenMemo(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Do("a", upper)
+	m.Do("b", upper)
+	// a was evicted from memory but is still on disk
+	if got := m.Do("a", upper); got != "A" || calls != 2 {
+		t.Errorf("expected A from disk, got %q after %d calls", got, calls)
+	}
+
+	reopened, err := OpenMemo(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Do("b", upper); got != "B" || calls != 2 {
+		t.Errorf("expected B from disk, got %q after %d calls", got, calls)
+	}
+	if stats := reopened.Stats(); stats.DiskHits != 1 || stats.Errors != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	DataSignerSalt = "salt"
+	defer func() { DataSignerSalt = "" }()
+	reopened.Do("b", upper)
+	if calls != 3 {
+		t.Errorf("result cached for another salt")
+	}
+}
+
+func TestMemoSigner(t *testing.T) {
+	Crc32Memo, Md5Memo = NewMemo(0), NewMemo(0)
+	defer func() { Crc32Memo, Md5Memo = nil, nil }()
+
+	results, err := Collect(context.Background(), HashSigner(4), []int{1, 1, 1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Repeat("_4958044192186797981418233587017209679042592862002427381542", 4)[1:]
+	if len(results) != 1 || results[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+
+	// 2 crc32 in SingleHash and 6 in MultiHash for the single distinct value
+	if stats := Crc32Memo.Stats(); stats.Misses != 8 || stats.Hits+stats.Shared != 24 {
+		t.Errorf("unexpected crc32 stats %+v", stats)
+	}
+	if stats := Md5Memo.Stats(); stats.Misses != 1 || stats.Hits+stats.Shared != 3 {
+		t.Errorf("unexpected md5 stats %+v", stats)
+	}
+}
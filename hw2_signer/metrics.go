@@ -0,0 +1,179 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LatencyBounds are the upper bounds of the latency histogram buckets.
+var LatencyBounds = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// maxPending bounds the receive times kept per stage for latencies. Stages
+// which answer many items with one, like CombineResults, stop recording
+// latencies past it rather than growing without limit.
+const maxPending = 1 << 16
+
+// Metrics records what the stages of pipelines observed with
+// Pipeline.Observe do. It serves them in the Prometheus text format.
+type Metrics struct {
+	mu     sync.Mutex
+	stages []*stageMetrics
+}
+
+// StageStats is a snapshot of the metrics of a stage.
+type StageStats struct {
+	Name    string
+	In      int64 // items received
+	Out     int64 // items sent
+	Backlog int   // items waiting in the input channel
+	Latency Histogram
+}
+
+// Histogram counts latencies by bucket. Latency is the time from the
+// oldest item received and not answered yet to the next item sent, which
+// is the processing time for stages sending one item per item in order.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int64 // per bucket, the last one counts latencies above all bounds
+	Count  int64
+	Sum    time.Duration
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+type stageMetrics struct {
+	mu      sync.Mutex
+	name    string
+	in      int64
+	out     int64
+	pending []time.Time // receive times of the items not answered yet
+	latency Histogram
+	queue   chan interface{} // the input channel, for the backlog
+}
+
+// stage returns the metrics of stage num, setting its name and input
+// channel. Metrics of later runs add up.
+func (m *Metrics) stage(num int, name string, queue chan interface{}) *stageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.stages) <= num {
+		m.stages = append(m.stages, &stageMetrics{latency: Histogram{
+			Bounds: LatencyBounds,
+			Counts: make([]int64, len(LatencyBounds)+1),
+		}})
+	}
+	sm := m.stages[num]
+	sm.mu.Lock()
+	sm.name, sm.queue = name, queue
+	sm.mu.Unlock()
+	return sm
+}
+
+func (sm *stageMetrics) received() {
+	sm.mu.Lock()
+	sm.in++
+	if len(sm.pending) < maxPending {
+		sm.pending = append(sm.pending, time.Now())
+	}
+	sm.mu.Unlock()
+}
+
+func (sm *stageMetrics) sent() {
+	sm.mu.Lock()
+	sm.out++
+	if len(sm.pending) > 0 {
+		sm.latency.observe(time.Since(sm.pending[0]))
+		sm.pending = sm.pending[1:]
+	}
+	sm.mu.Unlock()
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	bucket := 0
+	for bucket < len(h.Bounds) && d > h.Bounds[bucket] {
+		bucket++
+	}
+	h.Counts[bucket]++
+	h.Count++
+	h.Sum += d
+}
+
+// Snapshot returns the metrics of every stage.
+func (m *Metrics) Snapshot() []StageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]StageStats, len(m.stages))
+	for i, sm := range m.stages {
+		sm.mu.Lock()
+		stats[i] = StageStats{
+			Name:    sm.name,
+			In:      sm.in,
+			Out:     sm.out,
+			Backlog: len(sm.queue),
+			Latency: sm.latency,
+		}
+		stats[i].Latency.Counts = append([]int64(nil), sm.latency.Counts...)
+		sm.mu.Unlock()
+	}
+	return stats
+}
+
+// Publish exports the snapshot as the expvar name.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+// WritePrometheus writes the snapshot in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	stats := m.Snapshot()
+	metrics := []struct {
+		name, kind, help string
+		value            func(s StageStats) int64
+	}{
+		{"pipeline_stage_items_in_total", "counter", "Items received by the stage.", func(s StageStats) int64 { return s.In }},
+		{"pipeline_stage_items_out_total", "counter", "Items sent by the stage.", func(s StageStats) int64 { return s.Out }},
+		{"pipeline_stage_backlog", "gauge", "Items waiting in the input channel of the stage.", func(s StageStats) int64 { return int64(s.Backlog) }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s{stage=%q} %d\n", metric.name, s.Name, metric.value(s))
+		}
+	}
+
+	const latency = "pipeline_stage_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Processing latency of the stage.\n# TYPE %s histogram\n", latency, latency)
+	for _, s := range stats {
+		var cumulative int64
+		for i, count := range s.Latency.Counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(s.Latency.Bounds) {
+				le = fmt.Sprint(s.Latency.Bounds[i].Seconds())
+			}
+			fmt.Fprintf(w, "%s_bucket{stage=%q,le=%q} %d\n", latency, s.Name, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum{stage=%q} %g\n", latency, s.Name, s.Latency.Sum.Seconds())
+		if _, err := fmt.Fprintf(w, "%s_count{stage=%q} %d\n", latency, s.Name, s.Latency.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the snapshot in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
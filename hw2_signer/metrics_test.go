@@ -0,0 +1,111 @@
+package main
+
+import (
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	NewPipeline().
+		Observe(m).
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 10; i++ {
+				out <- i
+			}
+		}), 1, 0).Name("generator").
+		Stage(Map(func(data interface{}) interface{} {
+			time.Sleep(20 * time.Millisecond)
+			return data
+		}), 2, 0).Name("sleep").
+		Stage(job(func(in, out chan interface{}) {
+			drain(in)
+		}), 1, 0).Name("sink").
+		Run()
+
+	stats := m.Snapshot()
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 stages, got %d", len(stats))
+	}
+	expected := []struct {
+		name    string
+		in, out int64
+	}{{"generator", 0, 10}, {"sleep", 10, 10}, {"sink", 10, 0}}
+	for i, e := range expected {
+		s := stats[i]
+		if s.Name != e.name || s.In != e.in || s.Out != e.out || s.Backlog != 0 {
+			t.Errorf("stage %d: expected %s with %d in and %d out, got %+v", i, e.name, e.in, e.out, s)
+		}
+	}
+
+	latency := stats[1].Latency
+	if latency.Count != 10 || latency.Sum < 10*20*time.Millisecond {
+		t.Errorf("expected 10 latencies of at least 20ms, got %+v", latency)
+	}
+	// 20ms falls in the (10ms, 50ms] bucket
+	if latency.Counts[3] == 0 {
+		t.Errorf("expected latencies in the 50ms bucket, got %v", latency.Counts)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`pipeline_stage_items_in_total{stage="sleep"} 10`,
+		`pipeline_stage_items_out_total{stage="generator"} 10`,
+		`pipeline_stage_backlog{stage="sink"} 0`,
+		`pipeline_stage_latency_seconds_bucket{stage="sleep",le="+Inf"} 10`,
+		`pipeline_stage_latency_seconds_count{stage="sleep"} 10`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, body)
+		}
+	}
+
+	// expvar names cannot be published twice, as with -count
+	if expvar.Get("test_pipeline") == nil {
+		m.Publish("test_pipeline")
+	}
+	if v := expvar.Get("test_pipeline").String(); !strings.Contains(v, `"Name":"sleep"`) {
+		t.Errorf("unexpected expvar %s", v)
+	}
+}
+
+func TestMetricsBacklog(t *testing.T) {
+	m := NewMetrics()
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewPipeline().
+			Observe(m).
+			Stage(job(func(in, out chan interface{}) {
+				for i := 0; i < 3; i++ {
+					out <- i
+				}
+			}), 1, 5).
+			Stage(job(func(in, out chan interface{}) {
+				<-release
+				drain(in)
+			}), 1, 0).
+			Run()
+	}()
+
+	// the relay in front of the blocked stage holds one item
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats := m.Snapshot()
+		if len(stats) == 2 && stats[1].In == 1 && stats[1].Backlog == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a backlog of 2, got %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+}
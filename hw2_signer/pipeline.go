@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ctxJob is a job which can fail and should return early once ctx is done.
+type ctxJob func(ctx context.Context, in, out chan interface{}) error
+
+// ExecutePipelineContext is ExecutePipeline for jobs which can fail. The
+// first error cancels the context of all jobs and is returned once every
+// one of them has finished.
+func ExecutePipelineContext(ctx context.Context, jobs ...ctxJob) error {
+	p := NewPipeline()
+	for _, j := range jobs {
+		p.StageContext(j, 1, 0)
+	}
+	return p.RunContext(ctx)
+}
+
+// Pipeline runs jobs connected by channels, like ExecutePipeline, but lets
+// every stage limit how many goroutines run it and how many items may wait
+// in its output channel.
+type Pipeline struct {
+	stages      []stage
+	metrics     *Metrics
+	deadLetters chan<- DeadLetter
+}
+
+type stage struct {
+	j       ctxJob
+	f       ItemFunc // set for stages added with StageFunc instead of j
+	retry   RetryPolicy
+	name    string
+	workers int
+	buffer  int
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Stage appends j run by workers goroutines, which share its input and
+// output channels. The output channel holds up to buffer items. The job
+// should handle its items one at a time, see Map, for workers to be the
+// limit of items processed in parallel.
+func (p *Pipeline) Stage(j job, workers, buffer int) *Pipeline {
+	return p.StageContext(func(ctx context.Context, in, out chan interface{}) error {
+		j(in, out)
+		return nil
+	}, workers, buffer)
+}
+
+// StageContext is Stage for a job which can fail.
+func (p *Pipeline) StageContext(j ctxJob, workers, buffer int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	if buffer < 0 {
+		buffer = 0
+	}
+	name := fmt.Sprintf("stage %d", len(p.stages))
+	p.stages = append(p.stages, stage{j: j, name: name, workers: workers, buffer: buffer})
+	return p
+}
+
+// StageFunc appends a stage calling f on every item, on up to workers items
+// at once, and writing the results in input order like OrderedMap. Failed
+// calls are retried as set with Retry. Items failing every attempt go to
+// the dead letters, see DeadLetters, or fail the pipeline.
+func (p *Pipeline) StageFunc(f ItemFunc, workers, buffer int) *Pipeline {
+	p.StageContext(nil, workers, buffer)
+	p.stages[len(p.stages)-1].f = f
+	return p
+}
+
+// Retry sets the retry policy of the last stage. It panics unless the stage
+// was added with StageFunc, jobs don't retry their items.
+func (p *Pipeline) Retry(policy RetryPolicy) *Pipeline {
+	if len(p.stages) == 0 || p.stages[len(p.stages)-1].f == nil {
+		panic("pipeline: Retry on a stage not added with StageFunc")
+	}
+	p.stages[len(p.stages)-1].retry = policy
+	return p
+}
+
+// DeadLetters sends the items failing in stages added with StageFunc to ch
+// instead of failing the pipeline. Sending blocks, so ch must be read or
+// have room while the pipeline runs. It is not closed by the pipeline.
+func (p *Pipeline) DeadLetters(ch chan<- DeadLetter) *Pipeline {
+	p.deadLetters = ch
+	return p
+}
+
+// Name names the last stage added in metrics and errors.
+func (p *Pipeline) Name(name string) *Pipeline {
+	p.stages[len(p.stages)-1].name = name
+	return p
+}
+
+// Observe records the metrics of the stages in m when p runs.
+func (p *Pipeline) Observe(m *Metrics) *Pipeline {
+	p.metrics = m
+	return p
+}
+
+// Run starts all stages and returns once every one of them has finished.
+// The input of the first stage is closed and the output of the last one is
+// discarded. A stage panic is raised again once the pipeline has stopped.
+func (p *Pipeline) Run() {
+	if err := p.RunContext(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+// RunContext is Run which stops all stages on the first error, panic or
+// when ctx is done. Jobs are not interrupted, they are expected to watch
+// their context, but the items they still send are drained so that none of
+// them blocks forever.
+func (p *Pipeline) RunContext(ctx context.Context) error {
+	g, stageCtx := newGroup(ctx)
+
+	in := make(chan interface{})
+	close(in)
+	for num, s := range p.stages {
+		out := make(chan interface{}, s.buffer)
+		var sm *stageMetrics
+		if p.metrics != nil {
+			sm = p.metrics.stage(num, s.name, in)
+		}
+		if s.f != nil {
+			// one job keeps the items in order, running f on the workers
+			s.j = itemJob(s.name, s.workers, s.f, s.retry, p.deadLetters)
+			s.workers = 1
+		}
+		s.start(stageCtx, g, in, out, sm)
+		in = out
+	}
+
+	go drain(in)
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// start runs the workers of s in g and closes out once all of them are done.
+// With metrics the items pass through relays counting them.
+func (s stage) start(ctx context.Context, g *group, in, out chan interface{}, sm *stageMetrics) {
+	jobIn, jobOut := in, out
+	if sm != nil {
+		jobIn, jobOut = make(chan interface{}), make(chan interface{})
+		g.Go(func() error {
+			defer close(jobIn)
+			for data := range in {
+				sm.received()
+				jobIn <- data
+			}
+			return nil
+		})
+		g.Go(func() error {
+			defer close(out)
+			for data := range jobOut {
+				sm.sent()
+				out <- data
+			}
+			return nil
+		})
+	}
+
+	workers := &sync.WaitGroup{}
+	for i := 0; i < s.workers; i++ {
+		workers.Add(1)
+		g.Go(func() error {
+			defer workers.Done()
+			if err := catch(func() error { return s.j(ctx, jobIn, jobOut) }); err != nil {
+				return fmt.Errorf("%s: %w", s.name, err)
+			}
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		workers.Wait()
+		close(jobOut)
+		// the previous stage may still be sending if this one stopped early
+		drain(jobIn)
+		return nil
+	})
+}
+
+// group runs functions until the first of them fails, which cancels the
+// context of the others.
+type group struct {
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+	cancel context.CancelFunc
+}
+
+func newGroup(ctx context.Context) (*group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &group{cancel: cancel}, ctx
+}
+
+// Go runs f in a new goroutine, a panic in it fails the group.
+func (g *group) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := catch(f); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait returns the first error once all functions have returned.
+func (g *group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// catch calls f, turning a panic into an error.
+func catch(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+// drain reads ch until it is closed.
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
+
+// Map returns a job applying f to its input items one at a time.
+func Map(f func(interface{}) interface{}) job {
+	return func(in, out chan interface{}) {
+		for data := range in {
+			out <- f(data)
+		}
+	}
+}
+
+// OrderedMap returns a job applying f to up to workers items at once and
+// sending the results in input order, see ApplyOrdered. Run it as a single
+// worker stage.
+func OrderedMap(workers int, f func(interface{}) interface{}) job {
+	apply := OrderedMapContext(workers, func(ctx context.Context, data interface{}) (interface{}, error) {
+		return f(data), nil
+	})
+	return func(in, out chan interface{}) {
+		if err := apply(context.Background(), in, out); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// OrderedMapContext is OrderedMap for a function which can fail.
+func OrderedMapContext(workers int, f ItemFunc) ctxJob {
+	apply := ApplyOrdered(workers, f)
+	return func(ctx context.Context, in, out chan interface{}) error {
+		return apply(ctx, in, out)
+	}
+}
+
+// MapContext is Map for a function which can fail. The job stops on the
+// first error or once ctx is done.
+func MapContext(f func(ctx context.Context, data interface{}) (interface{}, error)) ctxJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for {
+			var data interface{}
+			var ok bool
+			select {
+			case data, ok = <-in:
+				if !ok {
+					return nil
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			result, err := f(ctx, data)
+			if err != nil {
+				return err
+			}
+			if err := send(ctx, out, result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// send sends data to out unless ctx is done first.
+func send(ctx context.Context, out chan interface{}, data interface{}) error {
+	select {
+	case out <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipelineWorkers(t *testing.T) {
+	var running, maxRunning, received int32
+	NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 20; i++ {
+				out <- i
+			}
+		}), 1, 0).
+		Stage(Map(func(data interface{}) interface{} {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return data
+		}), 3, 0).
+		Stage(job(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddInt32(&received, 1)
+			}
+		}), 1, 0).
+		Run()
+
+	if received != 20 {
+		t.Errorf("expected 20 items, received %d", received)
+	}
+	if maxRunning != 3 {
+		t.Errorf("expected 3 items processed at once, got %d", maxRunning)
+	}
+}
+
+func TestPipelineBuffer(t *testing.T) {
+	sent := make(chan struct{})
+	NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 5; i++ {
+				out <- i
+			}
+			close(sent)
+		}), 1, 5).
+		Stage(job(func(in, out chan interface{}) {
+			select {
+			case <-sent:
+			case <-time.After(time.Second):
+				t.Errorf("buffered items were not sent before the next stage started reading")
+			}
+			for range in {
+			}
+		}), 1, 0).
+		Run()
+}
+
+func TestPipelineSigner(t *testing.T) {
+	testExpected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	testResult := "NOT_SET"
+
+	NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			out <- 0
+			out <- 1
+		}), 1, 0).
+		Stage(Map(SingleHashItem), 2, 2).
+		Stage(Map(MultiHashItem), 2, 2).
+		Stage(job(CombineResults), 1, 0).
+		Stage(job(func(in, out chan interface{}) {
+			testResult = (<-in).(string)
+		}), 1, 0).
+		Run()
+
+	if testResult != testExpected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, testExpected)
+	}
+}
+
+// counter is a job sending 0, 1, 2... until its context is done.
+func counter(ctx context.Context, in, out chan interface{}) error {
+	for i := 0; ; i++ {
+		if err := send(ctx, out, i); err != nil {
+			return err
+		}
+	}
+}
+
+// checkGoroutines fails when more goroutines are running than before,
+// giving the stopped ones a moment to exit.
+func checkGoroutines(t *testing.T, before int) {
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if now := runtime.NumGoroutine(); now > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, now)
+	}
+}
+
+func TestPipelineContextError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errBad := errors.New("bad item")
+
+	err := ExecutePipelineContext(context.Background(),
+		counter,
+		MapContext(func(ctx context.Context, data interface{}) (interface{}, error) {
+			if data.(int) == 3 {
+				return nil, errBad
+			}
+			return data, nil
+		}),
+		ctxJob(func(ctx context.Context, in, out chan interface{}) error {
+			drain(in)
+			return nil
+		}),
+	)
+
+	if !errors.Is(err, errBad) {
+		t.Errorf("expected %v, got %v", errBad, err)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestPipelinePanic(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	err := NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 10; i++ {
+				out <- "not a number"
+			}
+		}), 1, 0).
+		Stage(job(SingleHash), 1, 0).
+		Stage(job(MultiHash), 1, 0).
+		RunContext(context.Background())
+
+	if err == nil || !strings.Contains(err.Error(), "stage 1: panic") {
+		t.Errorf("expected panic of stage 1, got %v", err)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestPipelineCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := NewPipeline().
+		StageContext(counter, 1, 10).
+		Stage(Map(func(data interface{}) interface{} { return data }), 4, 10).
+		Stage(job(func(in, out chan interface{}) {
+			drain(in)
+		}), 1, 0).
+		RunContext(ctx)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestPipelineOrderedMap(t *testing.T) {
+	results := []int{}
+	NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 20; i++ {
+				out <- i
+			}
+		}), 1, 0).
+		Stage(OrderedMap(5, func(data interface{}) interface{} {
+			time.Sleep(time.Duration(20-data.(int)) * time.Millisecond)
+			return data
+		}), 1, 0).
+		Stage(job(func(in, out chan interface{}) {
+			for data := range in {
+				results = append(results, data.(int))
+			}
+		}), 1, 0).
+		Run()
+
+	for i, data := range results {
+		if data != i {
+			t.Fatalf("results out of order: %v", results)
+		}
+	}
+	if len(results) != 20 {
+		t.Errorf("expected 20 results, got %d", len(results))
+	}
+}
+
+func TestHashWorkers(t *testing.T) {
+	defer func(workers int, crc32, md5 func(string) string) {
+		HashWorkers, DataSignerCrc32, DataSignerMd5 = workers, crc32, md5
+	}(HashWorkers, DataSignerCrc32, DataSignerMd5)
+
+	var active, most int32
+	DataSignerCrc32 = func(data string) string {
+		n := atomic.AddInt32(&active, 1)
+		for m := atomic.LoadInt32(&most); n > m && !atomic.CompareAndSwapInt32(&most, m, n); m = atomic.LoadInt32(&most) {
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return data
+	}
+	DataSignerMd5 = func(data string) string { return data }
+	HashWorkers = 2
+
+	// each SingleHash item makes 2 calls at once, each MultiHash one 6
+	for _, c := range []struct {
+		name  string
+		j     job
+		input func(i int) interface{}
+		calls int32
+	}{
+		{"SingleHash", SingleHash, func(i int) interface{} { return i }, 2},
+		{"MultiHash", MultiHash, func(i int) interface{} { return strconv.Itoa(i) }, MultiHashFanout},
+	} {
+		most = 0
+		in, out := make(chan interface{}), make(chan interface{}, 20)
+		go func() {
+			for i := 0; i < 20; i++ {
+				in <- c.input(i)
+			}
+			close(in)
+		}()
+		c.j(in, out)
+		if len(out) != 20 {
+			t.Errorf("%s: got %d results, expected 20", c.name, len(out))
+		}
+		if limit := int32(HashWorkers) * c.calls; most > limit {
+			t.Errorf("%s: %d calls at once, expected at most %d", c.name, most, limit)
+		}
+	}
+}
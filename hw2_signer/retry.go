@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy says how often and how soon a failed call is retried. The
+// zero policy makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts in total, less than 2 means no retries
+	Backoff     time.Duration // delay before the first retry, doubled for every next one
+	MaxBackoff  time.Duration // limit of the delay, 0 means no limit
+	Jitter      float64       // fraction of the delay taken off at random, from 0 to 1
+}
+
+// delay returns how long to wait after attempt fails, counting from 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Do calls f until it succeeds or the attempts run out, returning the last
+// error and the number of attempts made. A panic in f counts as a failure.
+// Waiting between attempts stops once ctx is done.
+func (p RetryPolicy) Do(ctx context.Context, f func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := catch(f)
+		if err == nil || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		}
+	}
+}
+
+// DeadLetter is an item which failed all its attempts in a stage.
+type DeadLetter struct {
+	Stage    string
+	Item     interface{}
+	Attempts int
+	Err      error
+}
+
+func (d DeadLetter) Error() string {
+	return fmt.Sprintf("item %v failed after %d attempts: %v", d.Item, d.Attempts, d.Err)
+}
+
+func (d DeadLetter) Unwrap() error {
+	return d.Err
+}
+
+// ItemFunc processes one item of a stage added with Pipeline.StageFunc.
+type ItemFunc func(ctx context.Context, data interface{}) (interface{}, error)
+
+// itemJob returns the job of a stage calling f on up to workers items at
+// once as policy says, writing the results in input order. Items failing
+// every attempt are sent to dead, or fail the stage if dead is nil.
+func itemJob(name string, workers int, f ItemFunc, policy RetryPolicy, dead chan<- DeadLetter) ctxJob {
+	// itemResult is the result of an item, or nothing for a dead letter
+	type itemResult struct {
+		data interface{}
+		dead bool
+	}
+	apply := ApplyOrdered(workers, func(ctx context.Context, data interface{}) (itemResult, error) {
+		var result interface{}
+		attempts, err := policy.Do(ctx, func() error {
+			var err error
+			result, err = f(ctx, data)
+			return err
+		})
+		if err == nil {
+			return itemResult{data: result}, nil
+		}
+		if ctx.Err() != nil {
+			return itemResult{}, ctx.Err()
+		}
+		letter := DeadLetter{Stage: name, Item: data, Attempts: attempts, Err: err}
+		if dead == nil {
+			return itemResult{}, letter
+		}
+		return itemResult{dead: true}, emit(ctx, dead, letter)
+	})
+
+	return func(ctx context.Context, in, out chan interface{}) error {
+		g, ctx := newGroup(ctx)
+		results := make(chan itemResult)
+		g.Go(func() error {
+			defer close(results)
+			return apply(ctx, in, results)
+		})
+		g.Go(func() error {
+			defer drain(results)
+			for r := range results {
+				if r.dead {
+					continue
+				}
+				if err := send(ctx, out, r.data); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return g.Wait()
+	}
+}
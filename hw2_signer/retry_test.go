@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, e := range expected {
+		if d := p.delay(i + 1); d != e*time.Millisecond {
+			t.Errorf("delay(%d) = %s, expected %s", i+1, d, e*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(2); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("delay with jitter %s out of [10ms, 20ms]", d)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 4, Backoff: time.Millisecond}
+	calls := 0
+	attempts, err := p.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("expected success on the 3rd attempt, got %d attempts and %v", attempts, err)
+	}
+
+	attempts, err = p.Do(context.Background(), func() error {
+		panic("always")
+	})
+	if err == nil || attempts != 4 {
+		t.Errorf("expected 4 failed attempts, got %d and %v", attempts, err)
+	}
+}
+
+func TestPipelineDeadLetters(t *testing.T) {
+	errOdd := errors.New("odd item")
+	var mu sync.Mutex
+	tries := map[int]int{}
+	flaky := func(ctx context.Context, data interface{}) (interface{}, error) {
+		item := data.(int)
+		mu.Lock()
+		tries[item]++
+		try := tries[item]
+		mu.Unlock()
+		switch {
+		case item%2 == 1:
+			return nil, errOdd
+		case try < 2:
+			return nil, errors.New("flaky")
+		}
+		return item, nil
+	}
+
+	dead := make(chan DeadLetter, 10)
+	var results []int
+	err := NewPipeline().
+		DeadLetters(dead).
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 6; i++ {
+				out <- i
+			}
+		}), 1, 0).
+		StageFunc(flaky, 3, 0).Name("flaky").
+		Retry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Jitter: 0.5}).
+		Stage(job(func(in, out chan interface{}) {
+			for data := range in {
+				results = append(results, data.(int))
+			}
+		}), 1, 0).
+		RunContext(context.Background())
+	close(dead)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Ints(results)
+	if len(results) != 3 || results[0] != 0 || results[1] != 2 || results[2] != 4 {
+		t.Errorf("expected the even items, got %v", results)
+	}
+	letters := 0
+	for letter := range dead {
+		letters++
+		if letter.Stage != "flaky" || letter.Attempts != 3 || !errors.Is(letter, errOdd) || letter.Item.(int)%2 != 1 {
+			t.Errorf("unexpected dead letter %+v", letter)
+		}
+	}
+	if letters != 3 {
+		t.Errorf("expected 3 dead letters, got %d", letters)
+	}
+}
+
+func TestPipelineRetryFails(t *testing.T) {
+	errBad := errors.New("bad item")
+	err := NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			out <- 1
+		}), 1, 0).
+		StageFunc(func(ctx context.Context, data interface{}) (interface{}, error) {
+			return nil, errBad
+		}, 1, 0).
+		Retry(RetryPolicy{MaxAttempts: 2}).
+		RunContext(context.Background())
+
+	var letter DeadLetter
+	if !errors.As(err, &letter) || letter.Attempts != 2 || !errors.Is(err, errBad) {
+		t.Errorf("expected dead letter error after 2 attempts, got %v", err)
+	}
+}
+
+func TestPipelineRetryNeedsStageFunc(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected Retry on a Stage stage to panic")
+		}
+	}()
+	NewPipeline().
+		Stage(job(func(in, out chan interface{}) {}), 1, 0).
+		Retry(RetryPolicy{MaxAttempts: 2})
+}
+
+func TestHashFuncs(t *testing.T) {
+	defer func(crc32, md5 func(string) string) {
+		DataSignerCrc32, DataSignerMd5 = crc32, md5
+	}(DataSignerCrc32, DataSignerMd5)
+
+	// the first crc32 of every item fails, the retries succeed
+	var mu sync.Mutex
+	calls := map[string]int{}
+	DataSignerCrc32 = func(data string) string {
+		mu.Lock()
+		calls[data]++
+		n := calls[data]
+		mu.Unlock()
+		if n == 1 {
+			panic("crc32 overheat")
+		}
+		return "c" + data
+	}
+	DataSignerMd5 = func(data string) string { return "m" + data }
+
+	var results []string
+	err := NewPipeline().
+		Stage(job(func(in, out chan interface{}) {
+			for i := 0; i < 8; i++ {
+				out <- i
+			}
+		}), 1, 0).
+		StageFunc(SingleHashFunc, 4, 0).Retry(RetryPolicy{MaxAttempts: 2}).
+		StageFunc(MultiHashFunc, 4, 0).Retry(RetryPolicy{MaxAttempts: 2}).
+		Stage(job(func(in, out chan interface{}) {
+			for data := range in {
+				results = append(results, data.(string))
+			}
+		}), 1, 0).
+		RunContext(context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 8 {
+		t.Fatalf("expected 8 results, got %v", results)
+	}
+	for i, result := range results {
+		if expected := multiHash(singleHash(strconv.Itoa(i)), MultiHashFanout); result != expected {
+			t.Errorf("result %d: got %q, expected %q", i, result, expected)
+		}
+	}
+}
@@ -1,108 +1,208 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"strings"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
-func worker(wg *sync.WaitGroup, j job, in, out chan interface{}) {
-	defer wg.Done()
-	defer close(out)
-	j(in, out)
-}
-
 func ExecutePipeline(jobs ...job) {
-	in := make(chan interface{})
-	out := make(chan interface{})
-
-	wg := &sync.WaitGroup{}
+	p := NewPipeline()
+	for _, j := range jobs {
+		p.Stage(j, 1, 0)
+	}
+	p.Run()
+}
 
-	for _, job := range jobs {
-		wg.Add(1)
-		go worker(wg, job, in, out)
+// signCrc32 is DataSignerCrc32 going through Crc32Memo.
+func signCrc32(data string) string {
+	return Crc32Memo.Do(data, func(data string) string {
+		return DataSignerCrc32(data)
+	})
+}
 
-		in = out
-		out = make(chan interface{})
-	}
-	wg.Wait()
+// signMd5 is DataSignerMd5 going through Md5Memo and Md5Guard.
+func signMd5(data string) string {
+	return Md5Memo.Do(data, func(data string) string {
+		var md5 string
+		Md5Guard.Do(context.Background(), func() {
+			md5 = DataSignerMd5(data)
+		})
+		return md5
+	})
 }
 
 func calcCrc32(data string, out chan string) {
-	out <- DataSignerCrc32(data)
+	out <- signCrc32(data)
 }
 
-func calcCrc32Md5(data string, mu *sync.Mutex, out chan string) {
-	mu.Lock()
-	md5 := DataSignerMd5(data)
-	mu.Unlock()
+func calcCrc32Md5(data string, out chan string) {
+	out <- signCrc32(signMd5(data))
+}
 
-	out <- DataSignerCrc32(md5)
+// singleHash returns crc32(data)~crc32(md5(data)), computing both halves in
+// parallel.
+func singleHash(data string) string {
+	hash, err := trySingleHash(data)
+	if err != nil {
+		panic(err)
+	}
+	return hash
 }
 
-func workerSingleHash(data string, wg *sync.WaitGroup, mu *sync.Mutex, out chan interface{}) {
-	defer wg.Done()
-	crc32Ch := make(chan string)
-	crc32Ch2 := make(chan string)
+// trySingleHash is singleHash returning a panic of a signer call as an
+// error, so that a stage can retry it.
+func trySingleHash(data string) (string, error) {
+	crc32Ch := make(chan string, 1)
+	crc32Ch2 := make(chan string, 1)
+
+	g, _ := newGroup(context.Background())
+	g.Go(func() error {
+		calcCrc32(data, crc32Ch)
+		return nil
+	})
+	g.Go(func() error {
+		calcCrc32Md5(data, crc32Ch2)
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return "", err
+	}
 
-	go calcCrc32(data, crc32Ch)
-	go calcCrc32Md5(data, mu, crc32Ch2)
+	return fmt.Sprintf("%s~%s",
+		<-crc32Ch,
+		<-crc32Ch2,
+	), nil
+}
 
-	out <- fmt.Sprintf("%s~%s", 
-		<- crc32Ch, 
-		<- crc32Ch2,		
-	)
+// SingleHashItem is SingleHash for a single int item, to be run with Map.
+func SingleHashItem(rawData interface{}) interface{} {
+	return singleHash(fmt.Sprintf("%d", rawData.(int)))
 }
 
+// SingleHashFunc is SingleHash for a single int item, to be run with
+// Pipeline.StageFunc, which retries it when a signer call fails.
+func SingleHashFunc(ctx context.Context, rawData interface{}) (interface{}, error) {
+	return trySingleHash(fmt.Sprintf("%d", rawData.(int)))
+}
+
+// HashWorkers is how many items SingleHash and MultiHash hash at once.
+var HashWorkers = 100
+
 func SingleHash(in, out chan interface{}) {
 	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
+	// items already started are sent even when a later one panics
+	defer wg.Wait()
+	slots := make(chan struct{}, HashWorkers)
 	for rawData := range in {
-		wg.Add(1)
 		data := fmt.Sprintf("%d", rawData.(int))
 		fmt.Println("SingleHash", data)
-		go workerSingleHash(data, wg, mu, out)
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			out <- singleHash(data)
+		}()
 	}
-	wg.Wait()
 }
 
-func workerMultiHash(data string, wg *sync.WaitGroup, out chan interface{}) {
-	defer wg.Done()
+// MultiHashFanout is the number of hashes MultiHash concatenates.
+const MultiHashFanout = 6
 
-	subW := &sync.WaitGroup{}
-	crc32Items := make([]string, 6)
-	for i := 0; i < 6; i++ {
-		subW.Add(1)
-		go func(data string, wg *sync.WaitGroup, num int, result []string) {
-			defer wg.Done()
-			result[num] = DataSignerCrc32(data)
-		}(fmt.Sprintf("%d%s", i, data), subW, i, crc32Items)
+// multiHash concatenates crc32(th+data) for th=0..fanout-1, computed in
+// parallel.
+func multiHash(data string, fanout int) string {
+	hash, err := tryMultiHash(data, fanout)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}
+
+// tryMultiHash is multiHash returning a panic of a signer call as an error.
+func tryMultiHash(data string, fanout int) (string, error) {
+	g, _ := newGroup(context.Background())
+	crc32Items := make([]string, fanout)
+	for i := range crc32Items {
+		g.Go(func() error {
+			crc32Items[i] = signCrc32(fmt.Sprintf("%d%s", i, data))
+			return nil
+		})
 	}
-	subW.Wait()
-	var result strings.Builder
-	for _, crc32 := range crc32Items {
-		result.WriteString(crc32)
+	if err := g.Wait(); err != nil {
+		return "", err
 	}
-	out <- result.String()
+	return strings.Join(crc32Items, ""), nil
+}
+
+// MultiHashItem is MultiHash for a single string item, to be run with Map.
+func MultiHashItem(rawData interface{}) interface{} {
+	return multiHash(rawData.(string), MultiHashFanout)
+}
+
+// MultiHashFunc is MultiHash for a single string item, to be run with
+// Pipeline.StageFunc.
+func MultiHashFunc(ctx context.Context, rawData interface{}) (interface{}, error) {
+	return tryMultiHash(rawData.(string), MultiHashFanout)
 }
- 
-func MultiHash(in, out chan interface{}) {	
+
+func MultiHash(in, out chan interface{}) {
 	wg := &sync.WaitGroup{}
+	// items already started are sent even when a later one panics
+	defer wg.Wait()
+	slots := make(chan struct{}, HashWorkers)
 	for rawData := range in {
+		data := rawData.(string)
+		fmt.Println("MultiHash", data)
+		slots <- struct{}{}
 		wg.Add(1)
-		fmt.Println("MultiHash", rawData.(string))
-		go workerMultiHash(rawData.(string), wg, out)		
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			out <- multiHash(data, MultiHashFanout)
+		}()
 	}
-	wg.Wait()
 }
 
 func CombineResults(in, out chan interface{}) {
 	elements := []string{}
 	for data := range in {
 		fmt.Println("CombineResults", data.(string))
-		elements = append(elements, data.(string))		
+		elements = append(elements, data.(string))
 	}
 	sort.Sort(sort.StringSlice(elements))
 	out <- strings.Join(elements, "_")
-}
\ No newline at end of file
+}
+
+// HashSigner is the SingleHash, MultiHash and CombineResults chain as a
+// typed stage, hashing up to workers values at once in each step.
+func HashSigner(workers int) Stage[int, string] {
+	single := Apply(func(ctx context.Context, data int) (string, error) {
+		return singleHash(strconv.Itoa(data)), nil
+	})
+	multi := Apply(func(ctx context.Context, data string) (string, error) {
+		return multiHash(data, MultiHashFanout), nil
+	})
+	return Then(Then(FanOut(workers, single), FanOut(workers, multi)), combineResults)
+}
+
+// combineResults is CombineResults as a typed stage.
+func combineResults(ctx context.Context, in <-chan string, out chan<- string) error {
+	elements := []string{}
+	for {
+		data, ok, err := receive(ctx, in)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		elements = append(elements, data)
+	}
+	sort.Strings(elements)
+	return emit(ctx, out, strings.Join(elements, "_"))
+}
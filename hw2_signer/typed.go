@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Stage is a step of a typed pipeline. It reads In values from in until in
+// is closed or ctx is done and writes Out values to out, which it must not
+// close. Stages are chained with Then and run with Collect.
+type Stage[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+// Then chains first and second, the values written by first are read by
+// second. An error in one of them cancels the other.
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A, out chan<- C) error {
+		g, ctx := newGroup(ctx)
+		mid := make(chan B)
+		g.Go(func() error {
+			defer close(mid)
+			return first(ctx, in, mid)
+		})
+		g.Go(func() error {
+			// first may still be sending if second stopped early
+			defer drain(mid)
+			return second(ctx, mid, out)
+		})
+		return g.Wait()
+	}
+}
+
+// Apply returns a stage calling f on every value, one at a time. Use FanOut
+// to call it on several values at once.
+func Apply[In, Out any](f func(ctx context.Context, data In) (Out, error)) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		for {
+			data, ok, err := receive(ctx, in)
+			if !ok {
+				return err
+			}
+			result, err := f(ctx, data)
+			if err != nil {
+				return err
+			}
+			if err := emit(ctx, out, result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Filter returns a stage passing on the values for which keep is true.
+func Filter[T any](keep func(data T) bool) Stage[T, T] {
+	return func(ctx context.Context, in <-chan T, out chan<- T) error {
+		for {
+			data, ok, err := receive(ctx, in)
+			if !ok {
+				return err
+			}
+			if !keep(data) {
+				continue
+			}
+			if err := emit(ctx, out, data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// FanOut runs n copies of s reading the same input and writing the same
+// output, so up to n values are processed at once. Values are written in
+// completion order. n below 1 counts as 1.
+func FanOut[In, Out any](n int, s Stage[In, Out]) Stage[In, Out] {
+	if n < 1 {
+		n = 1
+	}
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		g, ctx := newGroup(ctx)
+		for i := 0; i < n; i++ {
+			g.Go(func() error {
+				return s(ctx, in, out)
+			})
+		}
+		return g.Wait()
+	}
+}
+
+// ApplyOrdered is FanOut(n, Apply(f)) writing the results in input order.
+// A result is held back until the ones before it are written; at most 2n
+// values are in progress or held, so a slow value stalls the stage rather
+// than growing the reorder buffer. n below 1 counts as 1.
+func ApplyOrdered[In, Out any](n int, f func(ctx context.Context, data In) (Out, error)) Stage[In, Out] {
+	if n < 1 {
+		n = 1
+	}
+	type item struct {
+		seq  int
+		data In
+	}
+	type result struct {
+		seq  int
+		data Out
+	}
+
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		g, ctx := newGroup(ctx)
+		window := make(chan struct{}, 2*n)
+		items := make(chan item)
+		results := make(chan result)
+
+		g.Go(func() error {
+			defer close(items)
+			for seq := 0; ; seq++ {
+				data, ok, err := receive(ctx, in)
+				if !ok {
+					return err
+				}
+				if err := emit(ctx, window, struct{}{}); err != nil {
+					return err
+				}
+				if err := emit(ctx, items, item{seq: seq, data: data}); err != nil {
+					return err
+				}
+			}
+		})
+
+		workers := &sync.WaitGroup{}
+		for i := 0; i < n; i++ {
+			workers.Add(1)
+			g.Go(func() error {
+				defer workers.Done()
+				for {
+					it, ok, err := receive(ctx, items)
+					if !ok {
+						return err
+					}
+					data, err := f(ctx, it.data)
+					if err != nil {
+						return err
+					}
+					if err := emit(ctx, results, result{seq: it.seq, data: data}); err != nil {
+						return err
+					}
+				}
+			})
+		}
+		g.Go(func() error {
+			workers.Wait()
+			close(results)
+			return nil
+		})
+
+		g.Go(func() error {
+			defer drain(results)
+			pending := map[int]Out{}
+			next := 0
+			for r := range results {
+				pending[r.seq] = r.data
+				for data, ok := pending[next]; ok; data, ok = pending[next] {
+					if err := emit(ctx, out, data); err != nil {
+						return err
+					}
+					delete(pending, next)
+					next++
+					<-window
+				}
+			}
+			return nil
+		})
+
+		return g.Wait()
+	}
+}
+
+// FanIn merges sources into one channel, which is closed once all of them
+// are closed. Once ctx is done the remaining values are dropped.
+func FanIn[T any](ctx context.Context, sources ...<-chan T) <-chan T {
+	out := make(chan T)
+	wg := &sync.WaitGroup{}
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source <-chan T) {
+			defer wg.Done()
+			defer drain(source)
+			for data := range source {
+				if emit(ctx, out, data) != nil {
+					return
+				}
+			}
+		}(source)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Batch returns a stage grouping values by size. The last batch holds the
+// values left when the input is closed. size below 1 counts as 1.
+func Batch[T any](size int) Stage[T, []T] {
+	if size < 1 {
+		size = 1
+	}
+	return func(ctx context.Context, in <-chan T, out chan<- []T) error {
+		batch := make([]T, 0, size)
+		for {
+			data, ok, err := receive(ctx, in)
+			if !ok {
+				if err == nil && len(batch) > 0 {
+					err = emit(ctx, out, batch)
+				}
+				return err
+			}
+			batch = append(batch, data)
+			if len(batch) == size {
+				if err := emit(ctx, out, batch); err != nil {
+					return err
+				}
+				batch = make([]T, 0, size)
+			}
+		}
+	}
+}
+
+// FromJob runs j as a stage. A value sent by j which is not an Out fails
+// the stage instead of panicking further down.
+func FromJob[In, Out any](j job) Stage[In, Out] {
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		g, ctx := newGroup(ctx)
+		jobIn := make(chan interface{})
+		jobOut := make(chan interface{})
+
+		g.Go(func() error {
+			defer close(jobIn)
+			for {
+				data, ok, err := receive(ctx, in)
+				if !ok {
+					return err
+				}
+				if err := send(ctx, jobIn, data); err != nil {
+					return err
+				}
+			}
+		})
+		g.Go(func() error {
+			defer drain(jobIn)
+			defer close(jobOut)
+			j(jobIn, jobOut)
+			return nil
+		})
+		g.Go(func() error {
+			defer drain(jobOut)
+			for data := range jobOut {
+				result, ok := data.(Out)
+				if !ok {
+					return fmt.Errorf("job sent %T, expected %T", data, result)
+				}
+				if err := emit(ctx, out, result); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return g.Wait()
+	}
+}
+
+// Collect runs s on inputs and returns everything it writes.
+func Collect[In, Out any](ctx context.Context, s Stage[In, Out], inputs []In) ([]Out, error) {
+	g, ctx := newGroup(ctx)
+	in := make(chan In)
+	out := make(chan Out)
+
+	g.Go(func() error {
+		defer close(in)
+		for _, data := range inputs {
+			if err := emit(ctx, in, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	g.Go(func() error {
+		defer drain(in)
+		defer close(out)
+		return s(ctx, in, out)
+	})
+
+	var results []Out
+	for data := range out {
+		results = append(results, data)
+	}
+	return results, g.Wait()
+}
+
+// receive returns the next value of in. ok is false once in is closed or
+// ctx is done, err is set in the latter case.
+func receive[T any](ctx context.Context, in <-chan T) (data T, ok bool, err error) {
+	select {
+	case data, ok = <-in:
+		return data, ok, nil
+	case <-ctx.Done():
+		return data, false, ctx.Err()
+	}
+}
+
+// emit is send for typed channels.
+func emit[T any](ctx context.Context, out chan<- T, data T) error {
+	select {
+	case out <- data:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
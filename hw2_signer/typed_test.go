@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTypedSigner(t *testing.T) {
+	testExpected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+
+	results, err := Collect(context.Background(), HashSigner(2), []int{0, 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0] != testExpected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, testExpected)
+	}
+}
+
+func TestTypedCombinators(t *testing.T) {
+	double := Apply(func(ctx context.Context, data int) (int, error) {
+		return data * 2, nil
+	})
+	small := Filter(func(data int) bool { return data < 10 })
+
+	results, err := Collect(context.Background(), Then(Then(double, small), Batch[int](2)), []int{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := [][]int{{2, 4}, {6, 8}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", results, expected)
+	}
+}
+
+func TestTypedFanOutIn(t *testing.T) {
+	square := FanOut(3, Apply(func(ctx context.Context, data int) (int, error) {
+		return data * data, nil
+	}))
+	sum := 0
+	results, err := Collect(context.Background(), square, []int{1, 2, 3, 4})
+	for _, data := range results {
+		sum += data
+	}
+	if err != nil || sum != 1+4+9+16 {
+		t.Errorf("expected sum 30, got %d (%v)", sum, err)
+	}
+
+	a, b := make(chan int), make(chan int)
+	go func() {
+		a <- 1
+		close(a)
+	}()
+	go func() {
+		b <- 2
+		b <- 3
+		close(b)
+	}()
+	sum = 0
+	for data := range FanIn(context.Background(), a, b) {
+		sum += data
+	}
+	if sum != 6 {
+		t.Errorf("expected sum 6, got %d", sum)
+	}
+}
+
+func TestTypedFromJob(t *testing.T) {
+	results, err := Collect(context.Background(), Then(FromJob[int, string](SingleHash), FromJob[string, string](MultiHash)), []int{0})
+	if err != nil || len(results) != 1 || results[0] != "29568666068035183841425683795340791879727309630931025356555" {
+		t.Errorf("unexpected results %v (%v)", results, err)
+	}
+
+	toString := Map(func(data interface{}) interface{} { return strconv.Itoa(data.(int)) })
+	_, err = Collect(context.Background(), FromJob[int, int](toString), []int{0})
+	if err == nil || !strings.Contains(err.Error(), "job sent string, expected int") {
+		t.Errorf("expected type error, got %v", err)
+	}
+}
+
+func TestTypedError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errBad := errors.New("bad value")
+	fail := Apply(func(ctx context.Context, data int) (int, error) {
+		if data == 3 {
+			return 0, errBad
+		}
+		return data, nil
+	})
+	inputs := make([]int, 100)
+	for i := range inputs {
+		inputs[i] = i
+	}
+
+	_, err := Collect(context.Background(), Then(FanOut(4, fail), Filter(func(int) bool { return true })), inputs)
+	if !errors.Is(err, errBad) {
+		t.Errorf("expected %v, got %v", errBad, err)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestApplyOrdered(t *testing.T) {
+	var running, maxHeld int32
+	slow := ApplyOrdered(4, func(ctx context.Context, data int) (int, error) {
+		held := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxHeld)
+			if held <= max || atomic.CompareAndSwapInt32(&maxHeld, max, held) {
+				break
+			}
+		}
+		// early values take longest, so they finish last
+		time.Sleep(time.Duration(20-data%20) * time.Millisecond)
+		return data * 10, nil
+	})
+	count := Apply(func(ctx context.Context, data int) (int, error) {
+		atomic.AddInt32(&running, -1)
+		return data, nil
+	})
+	inputs := make([]int, 50)
+	for i := range inputs {
+		inputs[i] = i
+	}
+
+	results, err := Collect(context.Background(), Then(slow, count), inputs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, data := range results {
+		if data != i*10 {
+			t.Fatalf("results out of order: %v", results)
+		}
+	}
+	if len(results) != len(inputs) {
+		t.Errorf("expected %d results, got %d", len(inputs), len(results))
+	}
+	// values taken from the input but not written yet: the window and the
+	// one being written
+	if maxHeld > 2*4+1 {
+		t.Errorf("expected at most 9 values held, got %d", maxHeld)
+	}
+}
+
+func TestNoWorkers(t *testing.T) {
+	double := func(ctx context.Context, data int) (int, error) {
+		return data * 2, nil
+	}
+	for _, n := range []int{0, -1} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for name, s := range map[string]Stage[int, int]{
+			"ApplyOrdered": ApplyOrdered(n, double),
+			"FanOut":       FanOut(n, Apply(double)),
+		} {
+			results, err := Collect(ctx, s, []int{1, 2, 3})
+			if err != nil || len(results) != 3 {
+				t.Errorf("%s(%d): got %v, %v", name, n, results, err)
+			}
+		}
+		batches, err := Collect(ctx, Batch[int](n), []int{1, 2, 3})
+		if err != nil || len(batches) != 3 {
+			t.Errorf("Batch(%d): got %v, %v", n, batches, err)
+		}
+		cancel()
+	}
+
+	result := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ExecutePipeline(
+			job(func(in, out chan interface{}) { out <- 1 }),
+			OrderedMap(0, func(data interface{}) interface{} { return data.(int) * 2 }),
+			job(func(in, out chan interface{}) { result = (<-in).(int) }),
+		)
+	}()
+	select {
+	case <-done:
+		if result != 2 {
+			t.Errorf("OrderedMap(0): got %d, expected 2", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OrderedMap(0) never finished")
+	}
+}
+
+func TestApplyOrderedError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errBad := errors.New("bad value")
+	fail := ApplyOrdered(3, func(ctx context.Context, data int) (int, error) {
+		if data == 5 {
+			return 0, errBad
+		}
+		return data, nil
+	})
+
+	_, err := Collect(context.Background(), fail, []int{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	if !errors.Is(err, errBad) {
+		t.Errorf("expected %v, got %v", errBad, err)
+	}
+	checkGoroutines(t, before)
+}
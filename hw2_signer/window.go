@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window groups the items combined by CombineWindowed. The zero Window is
+// a single global window closed with the input, like CombineResults.
+type Window struct {
+	size, slide   int           // count based
+	length, every time.Duration // time based
+}
+
+// CountWindow returns windows of size items, a new one starting every
+// slide items. A slide of 0 or size makes tumbling windows.
+func CountWindow(size, slide int) Window {
+	if slide <= 0 {
+		slide = size
+	}
+	return Window{size: size, slide: slide}
+}
+
+// TimeWindow returns windows of the items received during length, a new
+// one starting every slide. A slide of 0 or length makes tumbling windows.
+func TimeWindow(length, slide time.Duration) Window {
+	if slide <= 0 {
+		slide = length
+	}
+	return Window{length: length, every: slide}
+}
+
+// ParseWindow parses a window written as "size", "size/slide", "length" or
+// "length/slide", where length and slide are durations like 10s. An empty
+// string is the global window.
+func ParseWindow(spec string) (Window, error) {
+	if spec == "" {
+		return Window{}, nil
+	}
+	size, slide, _ := strings.Cut(spec, "/")
+
+	if n, err := strconv.Atoi(size); err == nil {
+		step := 0
+		if slide != "" {
+			if step, err = strconv.Atoi(slide); err != nil {
+				return Window{}, fmt.Errorf("bad window slide %q", slide)
+			}
+		}
+		if n <= 0 || step < 0 {
+			return Window{}, fmt.Errorf("bad window %q", spec)
+		}
+		return CountWindow(n, step), nil
+	}
+
+	length, err := time.ParseDuration(size)
+	if err != nil {
+		return Window{}, fmt.Errorf("bad window %q", spec)
+	}
+	var step time.Duration
+	if slide != "" {
+		if step, err = time.ParseDuration(slide); err != nil {
+			return Window{}, fmt.Errorf("bad window slide %q", slide)
+		}
+	}
+	if length <= 0 || step < 0 {
+		return Window{}, fmt.Errorf("bad window %q", spec)
+	}
+	return TimeWindow(length, step), nil
+}
+
+// CombineWindowed is CombineResults sending a result per window instead of
+// one for the whole input: the sorted items of the window joined by "_".
+// Empty windows are skipped. When the input is closed, the windows started
+// and not sent yet are sent with the items they have, unless all of them
+// were already sent in an earlier window.
+func CombineWindowed(w Window) job {
+	switch {
+	case w.size > 0:
+		return func(in, out chan interface{}) {
+			combineCount(w.size, w.slide, in, out)
+		}
+	case w.length > 0:
+		return func(in, out chan interface{}) {
+			combineTime(w.length, w.every, in, out)
+		}
+	}
+	return func(in, out chan interface{}) {
+		var items []string
+		for data := range in {
+			items = append(items, data.(string))
+		}
+		out <- combine(items)
+	}
+}
+
+func combine(items []string) string {
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "_")
+}
+
+// combineCount sends windows of size items starting every slide items.
+func combineCount(size, slide int, in, out chan interface{}) {
+	var buf []string // items from the start of the next window on
+	start := 0       // index of the first item of the next window
+	seen := 0
+	sentUpTo := 0 // index after the last item sent
+
+	for data := range in {
+		if seen >= start {
+			buf = append(buf, data.(string))
+		}
+		seen++
+		if seen-start < size {
+			continue
+		}
+
+		out <- combine(buf[:size])
+		sentUpTo = seen
+		start += slide
+		if drop := slide; drop < len(buf) {
+			buf = buf[drop:]
+		} else {
+			buf = nil
+		}
+	}
+
+	for ; start < seen; start += slide {
+		if seen <= sentUpTo {
+			break
+		}
+		out <- combine(buf)
+		sentUpTo = seen
+		if slide < len(buf) {
+			buf = buf[slide:]
+		} else {
+			buf = nil
+		}
+	}
+}
+
+type timedItem struct {
+	at   time.Time
+	data string
+}
+
+// combineTime sends windows of the items received during length, starting
+// every slide from the first item on.
+func combineTime(length, slide time.Duration, in, out chan interface{}) {
+	var buf []timedItem
+	var start time.Time    // of the next window
+	var sentUpTo time.Time // arrival of the last item sent
+	var timer <-chan time.Time
+
+	// send sends the window from start on, holding the items received
+	// before end, and moves start to the next window. Once the input is
+	// closed, windows holding only items already sent are skipped.
+	send := func(end time.Time, closed bool) {
+		var items []string
+		for _, item := range buf {
+			if item.at.Before(end) {
+				items = append(items, item.data)
+			}
+		}
+		if len(items) > 0 && (!closed || buf[len(items)-1].at.After(sentUpTo)) {
+			out <- combine(items)
+			sentUpTo = buf[len(items)-1].at
+		}
+
+		start = start.Add(slide)
+		kept := buf[:0]
+		for _, item := range buf {
+			if !item.at.Before(start) {
+				kept = append(kept, item)
+			}
+		}
+		buf = kept
+	}
+
+	for {
+		select {
+		case data, ok := <-in:
+			if !ok {
+				for len(buf) > 0 {
+					send(start.Add(length), true)
+				}
+				return
+			}
+			now := time.Now()
+			if start.IsZero() {
+				start = now
+				timer = time.After(length)
+			}
+			buf = append(buf, timedItem{at: now, data: data.(string)})
+		case <-timer:
+			send(start.Add(length), false)
+			timer = time.After(time.Until(start.Add(length)))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runWindow sends batches of items through CombineWindowed, waiting pause
+// between the batches, and returns the windows sent.
+func runWindow(w Window, batches [][]string, pause time.Duration) []string {
+	var windows []string
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for i, batch := range batches {
+				if i > 0 {
+					time.Sleep(pause)
+				}
+				for _, item := range batch {
+					out <- item
+				}
+			}
+		}),
+		CombineWindowed(w),
+		job(func(in, out chan interface{}) {
+			for data := range in {
+				windows = append(windows, data.(string))
+			}
+		}),
+	)
+	return windows
+}
+
+func TestCombineCountWindow(t *testing.T) {
+	// items sort differently as numbers and as strings
+	items := [][]string{{"9", "8", "10", "7", "6", "11", "5"}}
+	cases := []struct {
+		window   Window
+		expected []string
+	}{
+		{Window{}, []string{"10_11_5_6_7_8_9"}},
+		{CountWindow(3, 0), []string{"10_8_9", "11_6_7", "5"}},
+		{CountWindow(4, 2), []string{"10_7_8_9", "10_11_6_7", "11_5_6"}},
+		{CountWindow(2, 3), []string{"8_9", "6_7", "5"}},
+		{CountWindow(7, 0), []string{"10_11_5_6_7_8_9"}},
+	}
+	for _, c := range cases {
+		if got := runWindow(c.window, items, 0); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("window %+v: got %v, expected %v", c.window, got, c.expected)
+		}
+	}
+}
+
+func TestCombineTimeWindow(t *testing.T) {
+	// batches at 0 and 150ms in windows of 100ms
+	got := runWindow(TimeWindow(100*time.Millisecond, 0), [][]string{{"0", "1"}, {"2", "3"}}, 150*time.Millisecond)
+	expected := []string{"0_1", "2_3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("tumbling: got %v, expected %v", got, expected)
+	}
+
+	// batches at 0, 80 and 160ms in windows of 100ms every 50ms: [0, 100),
+	// [50, 150), [100, 200) cut short by the end of the input, and
+	// [150, 250) holding nothing new
+	got = runWindow(TimeWindow(100*time.Millisecond, 50*time.Millisecond), [][]string{{"0", "1"}, {"2"}, {"3"}}, 80*time.Millisecond)
+	expected = []string{"0_1_2", "2", "3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("sliding: got %v, expected %v", got, expected)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	cases := []struct {
+		spec     string
+		expected Window
+	}{
+		{"", Window{}},
+		{"10", CountWindow(10, 10)},
+		{"10/5", CountWindow(10, 5)},
+		{"2s", TimeWindow(2*time.Second, 2*time.Second)},
+		{"1m/10s", TimeWindow(time.Minute, 10*time.Second)},
+	}
+	for _, c := range cases {
+		if w, err := ParseWindow(c.spec); err != nil || w != c.expected {
+			t.Errorf("ParseWindow(%q) = %+v, %v, expected %+v", c.spec, w, err, c.expected)
+		}
+	}
+
+	for _, spec := range []string{"0", "10/x", "-1s", "soon", "5/-1"} {
+		if _, err := ParseWindow(spec); err == nil {
+			t.Errorf("ParseWindow(%q): expected error", spec)
+		}
+	}
+}
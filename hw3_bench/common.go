@@ -14,6 +14,12 @@ import (
 const filePath string = "./data/users.txt"
 
 func SlowSearch(out io.Writer) {
+	SlowSearchQuery(out, DefaultQuery)
+}
+
+// SlowSearchQuery writes the users passing q and the number of unique
+// browsers matched by the browsers terms of q.
+func SlowSearchQuery(out io.Writer, q *Query) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		panic(err)
@@ -44,45 +50,23 @@ func SlowSearch(out io.Writer) {
 
 	for i, user := range users {
 
-		isAndroid := false
-		isMSIE := false
-
 		browsers, ok := user["browsers"].([]interface{})
 		if !ok {
 			// log.Println("cant cast browsers")
 			continue
 		}
 
+		u := User{Browsers: []string{}}
+		u.Name, _ = user["name"].(string)
+		u.Email, _ = user["email"].(string)
 		for _, browserRaw := range browsers {
 			browser, ok := browserRaw.(string)
 			if !ok {
 				// log.Println("cant cast browser to string")
 				continue
 			}
-			if ok, err := regexp.MatchString("Android", browser); ok && err == nil {
-				isAndroid = true
-				notSeenBefore := true
-				for _, item := range seenBrowsers {
-					if item == browser {
-						notSeenBefore = false
-					}
-				}
-				if notSeenBefore {
-					// log.Printf("SLOW New browser: %s, first seen: %s", browser, user["name"])
-					seenBrowsers = append(seenBrowsers, browser)
-					uniqueBrowsers++
-				}
-			}
-		}
-
-		for _, browserRaw := range browsers {
-			browser, ok := browserRaw.(string)
-			if !ok {
-				// log.Println("cant cast browser to string")
-				continue
-			}
-			if ok, err := regexp.MatchString("MSIE", browser); ok && err == nil {
-				isMSIE = true
+			u.Browsers = append(u.Browsers, browser)
+			if q.MatchBrowser(browser) {
 				notSeenBefore := true
 				for _, item := range seenBrowsers {
 					if item == browser {
@@ -97,11 +81,11 @@ func SlowSearch(out io.Writer) {
 			}
 		}
 
-		if !(isAndroid && isMSIE) {
+		if !q.Match(&u) {
 			continue
 		}
 
-		// log.Println("Android and MSIE user:", user["name"], user["email"])
+		// log.Println("found user:", user["name"], user["email"])
 		email := r.ReplaceAllString(user["email"].(string), " [at] ")
 		foundUsers += fmt.Sprintf("[%d] %s <%s>\n", i, user["name"], email)
 	}
@@ -84,6 +84,12 @@ func (v *User) UnmarshalJSON(data []byte) error {
 }
 
 func FastSearch(out io.Writer) {
+	FastSearchQuery(out, DefaultQuery)
+}
+
+// FastSearchQuery is SlowSearchQuery without the allocations: it decodes
+// each line into the same User and matches it against the parsed q.
+func FastSearchQuery(out io.Writer, q *Query) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		panic(err)
@@ -97,6 +103,13 @@ func FastSearch(out io.Writer) {
 
 	scanner := bufio.NewScanner(file)
 	i := -1
+	seen := func(browser string) {
+		_, ok := seenBrowsers[browser]
+		if !ok {
+			seenBrowsers[browser] = Empty
+			uniqueBrowsers++
+		}
+	}
 
 	for scanner.Scan() {
 		i++
@@ -105,26 +118,7 @@ func FastSearch(out io.Writer) {
 			panic(err)
 		}
 
-		isAndroid := false
-		isMSIE := false
-		for _, browser := range user.Browsers {
-			switch {
-			case strings.Contains(browser, "Android"):
-				isAndroid = true
-			case strings.Contains(browser, "MSIE"):
-				isMSIE = true
-			default:
-				continue		
-			}
-
-			_, ok := seenBrowsers[browser]				
-				if !ok {
-					seenBrowsers[browser] = Empty
-					uniqueBrowsers++
-				}
-		}
-
-		if !(isAndroid && isMSIE) {
+		if !q.MatchCount(user, seen) {
 			continue
 		}		
 		
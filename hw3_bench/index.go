@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultIndexPath is where the index command writes the index of filePath.
+const DefaultIndexPath = "./data/users.idx"
+
+const indexMagic = "hw3 users index 2\n"
+
+// checksumTable is the CRC-32C table of the index checksums, which the
+// hash/crc32 package computes with the CPU instructions for it.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Index is an inverted index of the users file: the lines of the users
+// having each browser. It remembers the size and checksum of the file, a
+// file changed since then is searched without the index.
+//
+// On disk it is indexMagic followed by uvarints: the size, the checksum,
+// the number of lines and their offsets as deltas, then the number of
+// browsers and for each its length, bytes, number of lines and lines as
+// deltas.
+type Index struct {
+	size     int64
+	checksum uint32
+	offsets  []int64
+	browsers map[string][]int
+}
+
+// BuildIndex indexes filePath into the file path.
+func BuildIndex(path string) error {
+	return buildIndex(filePath, path)
+}
+
+func buildIndex(dataPath, path string) error {
+	data, err := mapFile(dataPath)
+	if err != nil {
+		return err
+	}
+	defer unmapFile(data)
+
+	ix := &Index{
+		size:     int64(len(data)),
+		checksum: crc32.Checksum(data, checksumTable),
+		browsers: make(map[string][]int),
+	}
+	s := &userScanner{}
+	for i, rest := 0, data; len(rest) > 0; i++ {
+		ix.offsets = append(ix.offsets, int64(len(data)-len(rest)))
+		var line []byte
+		line, rest = nextLine(rest)
+		if err := s.scan(line); err != nil {
+			return fmt.Errorf("%s line %d: %v", dataPath, i, err)
+		}
+
+		for _, browser := range s.user.Browsers {
+			lines, ok := ix.browsers[browser]
+			if !ok {
+				browser = strings.Clone(browser)
+			}
+			// a user listing a browser twice is one line
+			if len(lines) == 0 || lines[len(lines)-1] != i {
+				ix.browsers[browser] = append(lines, i)
+			}
+		}
+	}
+	return ix.write(path)
+}
+
+// write replaces the file path with the index, so a reader never sees a
+// partly written one.
+func (ix *Index) write(path string) error {
+	names := make([]string, 0, len(ix.browsers))
+	for browser := range ix.browsers {
+		names = append(names, browser)
+	}
+	sort.Strings(names)
+
+	buf := []byte(indexMagic)
+	buf = binary.AppendUvarint(buf, uint64(ix.size))
+	buf = binary.AppendUvarint(buf, uint64(ix.checksum))
+	buf = binary.AppendUvarint(buf, uint64(len(ix.offsets)))
+	buf = appendDeltas(buf, ix.offsets)
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, browser := range names {
+		buf = binary.AppendUvarint(buf, uint64(len(browser)))
+		buf = append(buf, browser...)
+		lines := ix.browsers[browser]
+		buf = binary.AppendUvarint(buf, uint64(len(lines)))
+		buf = appendDeltas(buf, lines)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func appendDeltas[T int | int64](buf []byte, values []T) []byte {
+	var prev T
+	for _, v := range values {
+		buf = binary.AppendUvarint(buf, uint64(v-prev))
+		prev = v
+	}
+	return buf
+}
+
+// OpenIndex reads the index written to path by BuildIndex.
+func OpenIndex(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ix, err := readIndex(bufio.NewReader(file), info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return ix, nil
+}
+
+func readIndex(r *bufio.Reader, size int64) (*Index, error) {
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != indexMagic {
+		return nil, errors.New("not a users index")
+	}
+
+	// the first error sticks, the values read after it are zeros
+	var err error
+	uvarint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		if v, err = binary.ReadUvarint(r); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return int64(v)
+	}
+	// count reads a length, each counted item takes at least a byte of the
+	// file
+	count := func() int {
+		n := uvarint()
+		if n > size && err == nil {
+			err = errors.New("corrupt length")
+			return 0
+		}
+		return int(n)
+	}
+
+	ix := &Index{size: uvarint(), checksum: uint32(uvarint())}
+	ix.offsets = make([]int64, count())
+	for i := range ix.offsets {
+		if ix.offsets[i] = uvarint(); i > 0 {
+			ix.offsets[i] += ix.offsets[i-1]
+		}
+		if (ix.offsets[i] < 0 || ix.offsets[i] > ix.size) && err == nil {
+			err = errors.New("corrupt offset")
+		}
+	}
+	ix.browsers = make(map[string][]int)
+	for n := count(); n > 0 && err == nil; n-- {
+		name := make([]byte, count())
+		if err == nil {
+			_, err = io.ReadFull(r, name)
+		}
+		lines := make([]int, count())
+		for i := range lines {
+			if lines[i] = int(uvarint()); i > 0 {
+				lines[i] += lines[i-1]
+			}
+			if (lines[i] < 0 || lines[i] >= len(ix.offsets)) && err == nil {
+				err = errors.New("corrupt line number")
+			}
+		}
+		ix.browsers[string(name)] = lines
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// Fresh reports whether the file at dataPath is still the one indexed. A
+// file of the same size is read to compare its checksum.
+func (ix *Index) Fresh(dataPath string) bool {
+	info, err := os.Stat(dataPath)
+	if err != nil || info.Size() != ix.size {
+		return false
+	}
+	data, err := mapFile(dataPath)
+	if err != nil {
+		return false
+	}
+	defer unmapFile(data)
+	return ix.fresh(data)
+}
+
+// fresh reports whether data is the content of the file indexed.
+func (ix *Index) fresh(data []byte) bool {
+	return int64(len(data)) == ix.size && crc32.Checksum(data, checksumTable) == ix.checksum
+}
+
+// IndexSearchQuery is MmapSearchQuery answered from the index at
+// indexPath. Only the lines of the users found are decoded, the index is
+// ignored and the file scanned when the index is missing, unreadable or
+// out of date, or q has terms on other fields than browsers.
+func IndexSearchQuery(out io.Writer, q *Query, indexPath string) {
+	indexSearch(out, q, filePath, indexPath)
+}
+
+// SearchIndex is Search answered from the index at indexPath like
+// IndexSearchQuery.
+func SearchIndex(q *Query, indexPath string, found func(Result) error) (int, error) {
+	return searchIndex(q, filePath, indexPath, found)
+}
+
+func indexSearch(out io.Writer, q *Query, dataPath, indexPath string) {
+	report(out, func(found func(Result) error) (int, error) {
+		return searchIndex(q, dataPath, indexPath, found)
+	})
+}
+
+func searchIndex(q *Query, dataPath, indexPath string, found func(Result) error) (int, error) {
+	// a bad index only loses the speed up
+	ix, err := OpenIndex(indexPath)
+	if err != nil {
+		return scanSearch(q, dataPath, found)
+	}
+	lines, ok := ix.lines(q.expr)
+	if !ok {
+		return scanSearch(q, dataPath, found)
+	}
+
+	data, err := mapFile(dataPath)
+	if err != nil {
+		return 0, err
+	}
+	defer unmapFile(data)
+	if !ix.fresh(data) {
+		return scanData(q, data, found)
+	}
+
+	s := &userScanner{}
+	for i := lines.next(0); i >= 0; i = lines.next(i + 1) {
+		line, _ := nextLine(data[ix.offsets[i]:])
+		if err := s.scan(line); err != nil {
+			return 0, fmt.Errorf("line %d: %v", i, err)
+		}
+		if err := found(s.result(i)); err != nil {
+			return 0, err
+		}
+	}
+
+	uniqueBrowsers := 0
+	for browser := range ix.browsers {
+		if q.MatchBrowser(browser) {
+			uniqueBrowsers++
+		}
+	}
+	return uniqueBrowsers, nil
+}
+
+// lines returns the lines of the users passing the expression n, if it
+// only has terms on browsers.
+func (ix *Index) lines(n node) (lineSet, bool) {
+	switch n := n.(type) {
+	case *term:
+		if n.field != fieldBrowsers {
+			return nil, false
+		}
+		set := newLineSet(len(ix.offsets))
+		for browser, lines := range ix.browsers {
+			if n.matchString(browser) {
+				for _, line := range lines {
+					set.add(line)
+				}
+			}
+		}
+		return set, true
+	case notNode:
+		set, ok := ix.lines(n.node)
+		if ok {
+			set.not(len(ix.offsets))
+		}
+		return set, ok
+	case andNode:
+		return ix.combine(n, func(a, b uint64) uint64 { return a & b })
+	case orNode:
+		return ix.combine(n, func(a, b uint64) uint64 { return a | b })
+	}
+	return nil, false
+}
+
+func (ix *Index) combine(pair [2]node, op func(a, b uint64) uint64) (lineSet, bool) {
+	a, ok := ix.lines(pair[0])
+	if !ok {
+		return nil, false
+	}
+	b, ok := ix.lines(pair[1])
+	if !ok {
+		return nil, false
+	}
+	for i := range a {
+		a[i] = op(a[i], b[i])
+	}
+	return a, true
+}
+
+// lineSet is a bitset of line numbers.
+type lineSet []uint64
+
+func newLineSet(lines int) lineSet {
+	return make(lineSet, (lines+63)/64)
+}
+
+func (s lineSet) add(line int) {
+	s[line/64] |= 1 << (line % 64)
+}
+
+// not complements the set of the lines up to lines.
+func (s lineSet) not(lines int) {
+	for i := range s {
+		s[i] = ^s[i]
+	}
+	if tail := lines % 64; tail != 0 {
+		s[len(s)-1] &= 1<<tail - 1
+	}
+}
+
+// next returns the first line in the set from line on, or -1.
+func (s lineSet) next(line int) int {
+	for i := line / 64; i < len(s); i++ {
+		word := s[i]
+		if i == line/64 {
+			word &= ^uint64(0) << (line % 64)
+		}
+		if word != 0 {
+			return i*64 + bits.TrailingZeros64(word)
+		}
+	}
+	return -1
+}
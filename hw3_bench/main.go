@@ -1,12 +1,60 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"flag"
 	"fmt"
+	"log"
+	"os"
 )
 
 func main() {
-	slowOut := new(bytes.Buffer)
-	FastSearch(slowOut)
-	fmt.Println(slowOut.String())
-}
\ No newline at end of file
+	query := flag.String("query", DefaultQueryString, "filter on the name, email and browsers of the users")
+	workers := flag.Int("j", 1, "workers decoding chunks of the file, 1 scans it sequentially")
+	index := flag.String("index", "", "search with the index at `path`, written by the index command")
+	format := flag.String("format", "text", "output format: text, json or csv")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: hw3_bench [flags]\n       hw3_bench [-index path] index\n\nSearches the users, or indexes them by browser.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.Arg(0) == "index" {
+		path := *index
+		if path == "" {
+			path = DefaultIndexPath
+		}
+		if err := BuildIndex(path); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	q, err := ParseQuery(*query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newWriter, ok := ResultFormats[*format]
+	if !ok {
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	search := func(found func(Result) error) (int, error) {
+		switch {
+		case *index != "":
+			return SearchIndex(q, *index, found)
+		case *workers == 1:
+			return Search(q, found)
+		default:
+			return SearchParallel(q, *workers, 0, found)
+		}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	err = WriteSearch(newWriter(out), search)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
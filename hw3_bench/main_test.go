@@ -2,8 +2,18 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // запускаем перед основными функциями по разу чтобы файл остался в памяти в файловом кеше
@@ -44,3 +54,458 @@ func BenchmarkFast(b *testing.B) {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+func TestParseQuery(t *testing.T) {
+	user := &User{
+		Name:     "Alice",
+		Email:    "alice@example.com",
+		Browsers: []string{"Mozilla/5.0 (Linux; Android 4.4)", "Mozilla/4.0 (compatible; MSIE 7.0)"},
+	}
+	cases := []struct {
+		query string
+		match bool
+	}{
+		{DefaultQueryString, true},
+		{`browsers ~ "Android" AND browsers ~ "Opera"`, false},
+		{`browsers ~ "Opera" or name = "Alice"`, true},
+		{`email ends "@example.com" AND NOT name starts "B"`, true},
+		{`NOT (name = "Alice" OR email ~ "bob")`, false},
+		{`name = "Bob" OR name = "Alice" AND email ~ "bob"`, false},
+		{`browsers starts "Mozilla/4.0" AND email = "alice@example.com"`, true},
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.query)
+		if err != nil {
+			t.Errorf("%s: %v", c.query, err)
+			continue
+		}
+		if got := q.Match(user); got != c.match {
+			t.Errorf("%s: got %v, expected %v", c.query, got, c.match)
+		}
+		var seen, counted []string
+		if got := q.MatchCount(user, func(browser string) { seen = append(seen, browser) }); got != c.match {
+			t.Errorf("%s: MatchCount got %v, expected %v", c.query, got, c.match)
+		}
+		for _, browser := range user.Browsers {
+			if q.MatchBrowser(browser) {
+				counted = append(counted, browser)
+			}
+		}
+		if !reflect.DeepEqual(seen, counted) {
+			t.Errorf("%s: MatchCount counted %q, expected %q", c.query, seen, counted)
+		}
+	}
+
+	// browsers under a NOT don't count
+	q := MustParseQuery(`browsers ~ "Android" AND NOT (name = "Bob" OR browsers ~ "MSIE")`)
+	if !q.MatchBrowser(user.Browsers[0]) || q.MatchBrowser(user.Browsers[1]) {
+		t.Errorf("expected only the Android browser to count")
+	}
+	if q := MustParseQuery(`NOT NOT browsers ~ "MSIE"`); !q.MatchBrowser(user.Browsers[1]) {
+		t.Errorf("expected a browser under two NOTs to count")
+	}
+
+	for _, bad := range []string{
+		``,
+		`browsers`,
+		`phone ~ "1"`,
+		`name ~ Alice`,
+		`name ~ "Alice`,
+		`name ~ "Alice" AND`,
+		`(name ~ "Alice"`,
+		`name ~ "Alice" name ~ "Bob"`,
+	} {
+		if _, err := ParseQuery(bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+
+	if n := testing.AllocsPerRun(100, func() { DefaultQuery.Match(user) }); n != 0 {
+		t.Errorf("Match allocates %v times", n)
+	}
+	seen := func(browser string) {}
+	if n := testing.AllocsPerRun(100, func() { DefaultQuery.MatchCount(user, seen) }); n != 0 {
+		t.Errorf("MatchCount allocates %v times", n)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	for _, query := range []string{
+		`browsers ~ "Android" AND NOT browsers ~ "MSIE" AND email ends ".com"`,
+		`name starts "A" OR browsers ~ "Opera"`,
+		`email ~ "mail"`,
+	} {
+		q := MustParseQuery(query)
+
+		slowOut := new(bytes.Buffer)
+		SlowSearchQuery(slowOut, q)
+		fastOut := new(bytes.Buffer)
+		FastSearchQuery(fastOut, q)
+
+		if slowOut.String() != fastOut.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", query, fastOut, slowOut)
+		}
+	}
+}
+
+func TestFastSearchParallel(t *testing.T) {
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+
+	for _, workers := range []int{0, 1, 3} {
+		for _, chunkSize := range []int64{0, 1, 100, 4096, 50000} {
+			out := new(bytes.Buffer)
+			FastSearchParallel(out, DefaultQuery, workers, chunkSize)
+			if out.String() != expected.String() {
+				t.Errorf("%d workers, chunks of %d: results not match\nGot:\n%v\nExpected:\n%v", workers, chunkSize, out, expected)
+			}
+		}
+	}
+}
+
+func TestMergeChunks(t *testing.T) {
+	const n, workers = 200, 3
+	var merged, lead int64
+	decode := func(i int) chunkResult {
+		if ahead := int64(i) - atomic.LoadInt64(&merged); ahead > atomic.LoadInt64(&lead) {
+			atomic.StoreInt64(&lead, ahead)
+		}
+		return chunkResult{lines: i}
+	}
+	// a slow merge, the workers would decode everything meanwhile
+	err := mergeChunks(n, workers, decode, func(result chunkResult) error {
+		if result.lines != int(merged) {
+			t.Errorf("merged chunk %d as chunk %d", result.lines, merged)
+		}
+		time.Sleep(100 * time.Microsecond)
+		atomic.AddInt64(&merged, 1)
+		return nil
+	})
+	if err != nil || merged != n {
+		t.Fatalf("merged %d chunks, %v", merged, err)
+	}
+	if lead > 2*workers {
+		t.Errorf("decoded %d chunks ahead of the merge, expected at most %d", lead, 2*workers)
+	}
+
+	errStop := errors.New("stop")
+	err = mergeChunks(n, workers, decode, func(result chunkResult) error {
+		if result.lines == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("expected the merge error, got %v", err)
+	}
+}
+
+func BenchmarkFastParallel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		FastSearchParallel(ioutil.Discard, DefaultQuery, 0, 64<<10)
+	}
+}
+
+func TestMmapSearch(t *testing.T) {
+	for _, query := range []string{
+		DefaultQueryString,
+		`browsers ~ "Android" AND NOT browsers ~ "MSIE" AND email ends ".com"`,
+		`name starts "A" OR browsers ~ "Opera"`,
+	} {
+		q := MustParseQuery(query)
+
+		fastOut := new(bytes.Buffer)
+		FastSearchQuery(fastOut, q)
+		mmapOut := new(bytes.Buffer)
+		MmapSearchQuery(mmapOut, q)
+
+		if mmapOut.String() != fastOut.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", query, mmapOut, fastOut)
+		}
+	}
+}
+
+func TestUserScanner(t *testing.T) {
+	cases := []struct {
+		line string
+		user User
+	}{
+		{`{}`, User{Browsers: []string{}}},
+		{`{"name":"Alice","email":"a@b.c","browsers":["x","y"]}`, User{"Alice", "a@b.c", []string{"x", "y"}}},
+		{` { "phone" : "1-2" , "tags": [{"a": "]}"}, 1, true], "n": -1.5e3, "name" : null, "browsers": null } `, User{Browsers: []string{}}},
+		{`{"name":"Al\"i\\ce\/","email":"Aé😀\ud800","browsers":["\ttab"]}`, User{"Al\"i\\ce/", "Aé😀�", []string{"\ttab"}}},
+	}
+	s := &userScanner{}
+	for _, c := range cases {
+		if err := s.scan([]byte(c.line)); err != nil {
+			t.Errorf("%s: %v", c.line, err)
+			continue
+		}
+		browsers := append([]string{}, s.user.Browsers...)
+		if s.user.Name != c.user.Name || s.user.Email != c.user.Email || !reflect.DeepEqual(browsers, c.user.Browsers) {
+			t.Errorf("%s: got %#v, expected %#v", c.line, s.user, c.user)
+		}
+	}
+
+	for _, bad := range []string{
+		``,
+		`{`,
+		`{"name"}`,
+		`{"name":"Alice"`,
+		`{"name":"Alice}`,
+		`{"name":1}`,
+		`{"browsers":"x"}`,
+		`{"browsers":["x",]}`,
+		`{"email":"\x"}`,
+		`{} {}`,
+	} {
+		if err := s.scan([]byte(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+
+	line := []byte(`{"browsers":["Mozilla/5.0 (Android)","MSIE 8.0"],"company":"x","email":"a@b.c","name":"Alice"}`)
+	if n := testing.AllocsPerRun(100, func() { s.scan(line) }); n != 0 {
+		t.Errorf("scan allocates %v times", n)
+	}
+}
+
+func BenchmarkMmap(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		MmapSearch(ioutil.Discard)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "users.txt")
+	indexPath := filepath.Join(dir, "users.idx")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{
+		DefaultQueryString,
+		`browsers ~ "Android" AND NOT browsers ~ "MSIE"`,
+		`NOT browsers ~ "Mozilla" OR browsers = "Opera/9.80 (X11; Linux i686; U; ru) Presto/2.8.131 Version/11.11"`,
+		`browsers ~ "MSIE" AND name starts "A"`,
+	}
+	check := func(when string) {
+		t.Helper()
+		for _, query := range queries {
+			q := MustParseQuery(query)
+			expected := new(bytes.Buffer)
+			mmapSearch(expected, q, dataPath)
+			out := new(bytes.Buffer)
+			indexSearch(out, q, dataPath, indexPath)
+			if out.String() != expected.String() {
+				t.Errorf("%s, %s: results not match\nGot:\n%v\nExpected:\n%v", when, query, out, expected)
+			}
+		}
+	}
+
+	check("without index")
+	if err := buildIndex(dataPath, indexPath); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := OpenIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ix.Fresh(dataPath) {
+		t.Error("index of the unchanged file is not fresh")
+	}
+	if _, ok := ix.lines(DefaultQuery.expr); !ok {
+		t.Error("index doesn't answer the default query")
+	}
+	check("with index")
+
+	// the lines move, a stale index would number them wrong
+	if err := os.WriteFile(dataPath, data[bytes.IndexByte(data, '\n')+1:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ix.Fresh(dataPath) {
+		t.Error("index of the changed file is fresh")
+	}
+	check("changed file")
+
+	// an edit keeping the size and modification time is seen as well
+	swapped := bytes.Replace(data, []byte("Android"), []byte("Andriod"), 1)
+	if err := os.WriteFile(dataPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildIndex(dataPath, indexPath); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataPath, swapped, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dataPath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if ix, err := OpenIndex(indexPath); err != nil || ix.Fresh(dataPath) {
+		t.Errorf("index of the edited file is fresh, or %v", err)
+	}
+	check("edited file")
+
+	if err := os.WriteFile(indexPath, []byte("not an index"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenIndex(indexPath); err == nil {
+		t.Error("expected an error opening a bad index")
+	}
+	check("bad index")
+
+	// a cut index fails to read past its magic
+	if err := buildIndex(dataPath, indexPath); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(indexPath, index[:len(index)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenIndex(indexPath); err == nil {
+		t.Error("expected an error opening a cut index")
+	}
+	check("cut index")
+}
+
+func BenchmarkIndex(b *testing.B) {
+	indexPath := filepath.Join(b.TempDir(), "users.idx")
+	if err := BuildIndex(indexPath); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IndexSearchQuery(ioutil.Discard, DefaultQuery, indexPath)
+	}
+}
+
+func TestResultWriters(t *testing.T) {
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+
+	results := []Result{}
+	uniqueBrowsers, err := Search(DefaultQuery, func(r Result) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no users found")
+	}
+
+	replay := func(found func(Result) error) (int, error) {
+		for _, r := range results {
+			if err := found(r); err != nil {
+				return 0, err
+			}
+		}
+		return uniqueBrowsers, nil
+	}
+
+	out := new(bytes.Buffer)
+	if err := WriteSearch(NewTextWriter(out), replay); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("text results not match\nGot:\n%v\nExpected:\n%v", out, expected)
+	}
+
+	out.Reset()
+	if err := WriteSearch(NewJSONWriter(out), replay); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(out)
+	for _, r := range results {
+		got := Result{}
+		if err := dec.Decode(&got); err != nil || got != r {
+			t.Errorf("json: got %+v (%v), expected %+v", got, err, r)
+		}
+	}
+	total := map[string]int{}
+	if err := dec.Decode(&total); err != nil || total["unique_browsers"] != uniqueBrowsers {
+		t.Errorf("json: got total %v (%v), expected %d", total, err, uniqueBrowsers)
+	}
+
+	out.Reset()
+	if err := WriteSearch(NewCSVWriter(out), replay); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(results)+1 || strings.Join(records[0], ",") != "line,name,email" {
+		t.Fatalf("csv: got %d records starting with %v", len(records), records[0])
+	}
+	for i, r := range results {
+		if got := records[i+1]; got[0] != strconv.Itoa(r.Line) || got[1] != r.Name || got[2] != r.Email {
+			t.Errorf("csv: got %v, expected %+v", got, r)
+		}
+	}
+
+	out.Reset()
+	if err := WriteSearch(NewTextWriter(out), func(found func(Result) error) (int, error) { return 0, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "found users:\n\nTotal unique browsers 0\n" {
+		t.Errorf("text without results: got %q", out)
+	}
+
+	// the results outlive the mapping of the file
+	indexPath := filepath.Join(t.TempDir(), "users.idx")
+	if err := BuildIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	indexed := []Result{}
+	if _, err := SearchIndex(DefaultQuery, indexPath, func(r Result) error {
+		indexed = append(indexed, r)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexed, results) {
+		t.Errorf("index results not match\nGot:\n%v\nExpected:\n%v", indexed, results)
+	}
+}
+
+func TestSearchStops(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "users.idx")
+	if err := BuildIndex(indexPath); err != nil {
+		t.Fatal(err)
+	}
+	stop := errors.New("stop")
+	searches := map[string]func(found func(Result) error) (int, error){
+		"Search": func(found func(Result) error) (int, error) { return Search(DefaultQuery, found) },
+		"SearchIndex": func(found func(Result) error) (int, error) {
+			return SearchIndex(DefaultQuery, indexPath, found)
+		},
+		"SearchParallel": func(found func(Result) error) (int, error) {
+			return SearchParallel(DefaultQuery, 4, 1000, found)
+		},
+	}
+	for name, search := range searches {
+		calls := 0
+		_, err := search(func(r Result) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Errorf("%s: got %v after %d calls, expected to stop after the first", name, err, calls)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+func MmapSearch(out io.Writer) {
+	MmapSearchQuery(out, DefaultQuery)
+}
+
+// MmapSearchQuery is FastSearchQuery on the memory mapped file, taking
+// the name, email and browsers of each line out of the raw bytes. The
+// strings of the User it matches point into the mapping, only the unique
+// browsers and the name and email of the users found are copied, so a
+// record costs no allocations.
+func MmapSearchQuery(out io.Writer, q *Query) {
+	mmapSearch(out, q, filePath)
+}
+
+func mmapSearch(out io.Writer, q *Query, path string) {
+	report(out, func(found func(Result) error) (int, error) {
+		return scanSearch(q, path, found)
+	})
+}
+
+// scanSearch is Search on the file path.
+func scanSearch(q *Query, path string, found func(Result) error) (int, error) {
+	data, err := mapFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer unmapFile(data)
+	return scanData(q, data, found)
+}
+
+// scanData is Search on data, the content of a users file.
+func scanData(q *Query, data []byte, found func(Result) error) (int, error) {
+	seenBrowsers := make(map[string]struct{})
+	// the browsers point into the mapping, the keys need a copy
+	seen := func(browser string) {
+		if _, ok := seenBrowsers[browser]; !ok {
+			seenBrowsers[strings.Clone(browser)] = Empty
+		}
+	}
+	s := &userScanner{}
+
+	for i := 0; len(data) > 0; i++ {
+		var line []byte
+		line, data = nextLine(data)
+		if err := s.scan(line); err != nil {
+			return 0, fmt.Errorf("line %d: %v", i, err)
+		}
+
+		if !q.MatchCount(&s.user, seen) {
+			continue
+		}
+		if err := found(s.result(i)); err != nil {
+			return 0, err
+		}
+	}
+	return len(seenBrowsers), nil
+}
+
+// nextLine splits the first line off data like bufio.ScanLines does.
+func nextLine(data []byte) (line, rest []byte) {
+	line = data
+	if n := bytes.IndexByte(data, '\n'); n >= 0 {
+		line, rest = data[:n], data[n+1:]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, rest
+}
+
+// userScanner takes the name, email and browsers out of a JSON object
+// line and skips the other fields. The strings it sets share memory with
+// the line, or with scratch when they have escapes, and are only valid
+// until the next scan.
+type userScanner struct {
+	line    []byte
+	pos     int
+	user    User
+	scratch []byte
+}
+
+func (s *userScanner) scan(line []byte) error {
+	s.line, s.pos = line, 0
+	s.user.Name, s.user.Email = "", ""
+	s.user.Browsers = s.user.Browsers[:0]
+	s.scratch = s.scratch[:0]
+
+	if !s.consume('{') {
+		return s.fail("expected {")
+	}
+	if s.consume('}') {
+		return s.end()
+	}
+	for {
+		key, _, ok := s.stringToken()
+		if !ok {
+			return s.fail("expected a key")
+		}
+		if !s.consume(':') {
+			return s.fail("expected :")
+		}
+
+		var err error
+		switch string(key) {
+		case "name":
+			s.user.Name, err = s.stringValue()
+		case "email":
+			s.user.Email, err = s.stringValue()
+		case "browsers":
+			err = s.browsers()
+		default:
+			err = s.skipValue()
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.consume(',') {
+			continue
+		}
+		if s.consume('}') {
+			return s.end()
+		}
+		return s.fail("expected , or }")
+	}
+}
+
+// result returns the user scanned on line i as a Result, with copies of the
+// strings which outlive the mapping.
+func (s *userScanner) result(i int) Result {
+	return Result{i, strings.Clone(s.user.Name), strings.Clone(s.user.Email)}
+}
+
+func (s *userScanner) fail(msg string) error {
+	return fmt.Errorf("offset %d: %s", s.pos, msg)
+}
+
+func (s *userScanner) end() error {
+	s.skipSpace()
+	if s.pos != len(s.line) {
+		return s.fail("data after the object")
+	}
+	return nil
+}
+
+func (s *userScanner) skipSpace() {
+	for s.pos < len(s.line) {
+		switch s.line[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips c and the spaces before it, if c is next.
+func (s *userScanner) consume(c byte) bool {
+	s.skipSpace()
+	if s.pos < len(s.line) && s.line[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// literal skips null, true, false or a number.
+func (s *userScanner) literal() []byte {
+	s.skipSpace()
+	start := s.pos
+	for s.pos < len(s.line) && strings.IndexByte(",:{}[]\" \t\r\n", s.line[s.pos]) < 0 {
+		s.pos++
+	}
+	return s.line[start:s.pos]
+}
+
+// stringToken returns the content of the next string, still escaped.
+func (s *userScanner) stringToken() (raw []byte, escaped, ok bool) {
+	if !s.consume('"') {
+		return nil, false, false
+	}
+	start := s.pos
+	for s.pos < len(s.line) {
+		switch s.line[s.pos] {
+		case '"':
+			s.pos++
+			return s.line[start : s.pos-1], escaped, true
+		case '\\':
+			escaped = true
+			s.pos++
+		}
+		s.pos++
+	}
+	return nil, false, false
+}
+
+// stringValue returns the next string, or "" for null.
+func (s *userScanner) stringValue() (string, error) {
+	s.skipSpace()
+	if s.pos < len(s.line) && s.line[s.pos] == 'n' {
+		if string(s.literal()) != "null" {
+			return "", s.fail("expected a string")
+		}
+		return "", nil
+	}
+	raw, escaped, ok := s.stringToken()
+	if !ok {
+		return "", s.fail("expected a string")
+	}
+	if !escaped {
+		return bytesString(raw), nil
+	}
+	start := len(s.scratch)
+	if s.scratch, ok = appendUnescaped(s.scratch, raw); !ok {
+		return "", s.fail("bad escape")
+	}
+	return bytesString(s.scratch[start:]), nil
+}
+
+// browsers appends the strings of the next array, null being an empty one,
+// to the browsers of the user.
+func (s *userScanner) browsers() error {
+	s.skipSpace()
+	if s.pos < len(s.line) && s.line[s.pos] == 'n' {
+		return s.skipValue()
+	}
+	if !s.consume('[') {
+		return s.fail("expected [")
+	}
+	if s.consume(']') {
+		return nil
+	}
+	for {
+		browser, err := s.stringValue()
+		if err != nil {
+			return err
+		}
+		s.user.Browsers = append(s.user.Browsers, browser)
+		if s.consume(',') {
+			continue
+		}
+		if s.consume(']') {
+			return nil
+		}
+		return s.fail("expected , or ]")
+	}
+}
+
+// skipValue skips the next value of any kind.
+func (s *userScanner) skipValue() error {
+	s.skipSpace()
+	if s.pos == len(s.line) {
+		return s.fail("expected a value")
+	}
+	switch s.line[s.pos] {
+	case '"':
+		if _, _, ok := s.stringToken(); !ok {
+			return s.fail("unterminated string")
+		}
+	case '{', '[':
+		depth := 0
+		for s.pos < len(s.line) {
+			switch s.line[s.pos] {
+			case '"':
+				if _, _, ok := s.stringToken(); !ok {
+					return s.fail("unterminated string")
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			s.pos++
+			if depth == 0 {
+				return nil
+			}
+		}
+		return s.fail("unterminated value")
+	default:
+		if len(s.literal()) == 0 {
+			return s.fail("expected a value")
+		}
+	}
+	return nil
+}
+
+// appendUnescaped appends the JSON string content raw with its escapes
+// replaced to dst.
+func appendUnescaped(dst, raw []byte) ([]byte, bool) {
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if c != '\\' {
+			dst = append(dst, c)
+			continue
+		}
+		i++
+		if i == len(raw) {
+			return dst, false
+		}
+		switch raw[i] {
+		case '"', '\\', '/':
+			dst = append(dst, raw[i])
+		case 'b':
+			dst = append(dst, '\b')
+		case 'f':
+			dst = append(dst, '\f')
+		case 'n':
+			dst = append(dst, '\n')
+		case 'r':
+			dst = append(dst, '\r')
+		case 't':
+			dst = append(dst, '\t')
+		case 'u':
+			r, ok := hexRune(raw[i+1:])
+			if !ok {
+				return dst, false
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				// the second half of the pair is the next escape, a lone
+				// half becomes U+FFFD like in encoding/json
+				var r2 rune
+				if i+2 < len(raw) && raw[i+1] == '\\' && raw[i+2] == 'u' {
+					r2, _ = hexRune(raw[i+3:])
+				}
+				if r = utf16.DecodeRune(r, r2); r != utf8.RuneError {
+					i += 6
+				}
+			}
+			dst = utf8.AppendRune(dst, r)
+		default:
+			return dst, false
+		}
+	}
+	return dst, true
+}
+
+// hexRune returns the value of the four hex digits b starts with.
+func hexRune(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+// bytesString returns b as a string without copying it.
+func bytesString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
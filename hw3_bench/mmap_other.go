@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// mapFile reads the file name, where mmap isn't available.
+func mapFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
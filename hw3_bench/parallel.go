@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// DefaultChunkSize is the size of the byte ranges FastSearchParallel hands
+// to its workers.
+const DefaultChunkSize = 4 << 20
+
+// chunk is a byte range of the file starting at the beginning of a line and
+// ending after a newline or at the end of the file.
+type chunk struct {
+	start, end int64
+}
+
+// chunkResult is what a worker found in a chunk, lines are numbered from
+// the beginning of the chunk.
+type chunkResult struct {
+	lines    int
+	found    []foundUser
+	browsers map[string]struct{}
+	err      error
+}
+
+type foundUser struct {
+	line        int
+	name, email string
+}
+
+// FastSearchParallel is FastSearchQuery splitting the file into chunks of
+// about chunkSize bytes decoded by workers goroutines, with the same
+// output. Zero workers or chunkSize mean GOMAXPROCS and DefaultChunkSize.
+func FastSearchParallel(out io.Writer, q *Query, workers int, chunkSize int64) {
+	report(out, func(found func(Result) error) (int, error) {
+		return SearchParallel(q, workers, chunkSize, found)
+	})
+}
+
+// SearchParallel is Search decoding the file like FastSearchParallel. The
+// results of a chunk are passed to found once it and the chunks before it
+// are decoded.
+func SearchParallel(q *Query, workers int, chunkSize int64, found func(Result) error) (int, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	chunks, err := splitChunks(file, chunkSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// chunks are merged in file order, so line numbers only need the number
+	// of lines before the chunk
+	seenBrowsers := make(map[string]struct{})
+	line := 0
+	err = mergeChunks(len(chunks), workers, func(i int) chunkResult {
+		return searchChunk(file, chunks[i], q)
+	}, func(result chunkResult) error {
+		if result.err != nil {
+			return result.err
+		}
+		for browser := range result.browsers {
+			seenBrowsers[browser] = Empty
+		}
+		for _, user := range result.found {
+			if err := found(Result{line + user.line, user.name, user.email}); err != nil {
+				return err
+			}
+		}
+		line += result.lines
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(seenBrowsers), nil
+}
+
+// mergeChunks passes the results of decode for the chunks 0 to n-1, run by
+// workers goroutines, to merge in chunk order until it fails. The workers
+// stay at most 2*workers chunks ahead of merge, and a result is dropped
+// once merged, so a slow merge doesn't keep the whole file in memory. On
+// return the workers are done with their chunks.
+func mergeChunks(n, workers int, decode func(i int) chunkResult, merge func(result chunkResult) error) error {
+	// done[i] is closed once results[i] is set
+	results := make([]chunkResult, n)
+	done := make([]chan struct{}, n)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	next := make(chan int)
+	stop := make(chan struct{})
+	defer close(stop)
+	// a chunk takes a slot of window until it is merged
+	window := make(chan struct{}, 2*workers)
+	go func() {
+		defer close(next)
+		for i := 0; i < n; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case next <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = decode(i)
+				close(done[i])
+			}
+		}()
+	}
+
+	for i := range results {
+		<-done[i]
+		if err := merge(results[i]); err != nil {
+			return err
+		}
+		results[i] = chunkResult{}
+		<-window
+	}
+	return nil
+}
+
+// searchChunk decodes the lines of c like FastSearchQuery does.
+func searchChunk(file io.ReaderAt, c chunk, q *Query) chunkResult {
+	result := chunkResult{browsers: make(map[string]struct{})}
+	user := new(User)
+	seen := func(browser string) { result.browsers[browser] = Empty }
+
+	scanner := bufio.NewScanner(io.NewSectionReader(file, c.start, c.end-c.start))
+	for ; scanner.Scan(); result.lines++ {
+		if err := user.UnmarshalJSON(scanner.Bytes()); err != nil {
+			result.err = fmt.Errorf("line %d of chunk at %d: %v", result.lines, c.start, err)
+			return result
+		}
+
+		if q.MatchCount(user, seen) {
+			result.found = append(result.found, foundUser{result.lines, user.Name, user.Email})
+		}
+	}
+	result.err = scanner.Err()
+	return result
+}
+
+// splitChunks cuts the file into chunks of at least size bytes, each
+// extended to the end of the line it stops in.
+func splitChunks(file *os.File, size int64) ([]chunk, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	total := info.Size()
+
+	chunks := []chunk{}
+	buf := make([]byte, 4096)
+	for start := int64(0); start < total; {
+		end := start + size
+		for end < total {
+			n, err := file.ReadAt(buf, end-1)
+			if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+				end += int64(i)
+				break
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			end += int64(n)
+		}
+		if end > total {
+			end = total
+		}
+		chunks = append(chunks, chunk{start, end})
+		start = end
+	}
+	return chunks, nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultQueryString is the search the benchmarks were written for: users
+// with both an Android and an MSIE browser.
+const DefaultQueryString = `browsers ~ "Android" AND browsers ~ "MSIE"`
+
+var DefaultQuery = MustParseQuery(DefaultQueryString)
+
+// Query is a parsed filter expression like
+//
+//	browsers ~ "Android" AND (email ends "@example.com" OR NOT name starts "A")
+//
+// A term compares a field (name, email or browsers) with a quoted string
+// using ~ (contains), = (equals), starts or ends; a browsers term holds
+// when any of the browsers does. Terms combine with NOT, AND and OR, in
+// that order of precedence, and parentheses. Keywords are case insensitive.
+//
+// Matching a parsed query does not allocate.
+type Query struct {
+	expr node
+	// browsers are the terms on browsers in the order of their index, the
+	// browsers matched by the counted ones are the unique browsers of a
+	// search
+	browsers []*term
+}
+
+type field int
+
+const (
+	fieldName field = iota
+	fieldEmail
+	fieldBrowsers
+)
+
+var fields = map[string]field{"name": fieldName, "email": fieldEmail, "browsers": fieldBrowsers}
+
+type op int
+
+const (
+	opContains op = iota
+	opEquals
+	opStarts
+	opEnds
+)
+
+var ops = map[string]op{"~": opContains, "=": opEquals, "starts": opStarts, "ends": opEnds}
+
+type node interface {
+	match(u *User) bool
+	// matchMask is match taking the browsers terms from mask, see
+	// Query.browserMask
+	matchMask(u *User, mask uint64) bool
+}
+
+type term struct {
+	field field
+	op    op
+	value string
+	// index is the position of a browsers term in Query.browsers, counted
+	// tells it is not under a NOT
+	index   int
+	counted bool
+}
+
+type andNode [2]node
+type orNode [2]node
+type notNode struct{ node }
+
+func (t *term) match(u *User) bool {
+	switch t.field {
+	case fieldName:
+		return t.matchString(u.Name)
+	case fieldEmail:
+		return t.matchString(u.Email)
+	}
+	for _, browser := range u.Browsers {
+		if t.matchString(browser) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *term) matchString(s string) bool {
+	switch t.op {
+	case opEquals:
+		return s == t.value
+	case opStarts:
+		return strings.HasPrefix(s, t.value)
+	case opEnds:
+		return strings.HasSuffix(s, t.value)
+	}
+	return strings.Contains(s, t.value)
+}
+
+func (t *term) matchMask(u *User, mask uint64) bool {
+	if t.field != fieldBrowsers || t.index >= 64 {
+		return t.match(u)
+	}
+	return mask&(1<<t.index) != 0
+}
+
+func (n andNode) match(u *User) bool { return n[0].match(u) && n[1].match(u) }
+func (n orNode) match(u *User) bool  { return n[0].match(u) || n[1].match(u) }
+func (n notNode) match(u *User) bool { return !n.node.match(u) }
+
+func (n andNode) matchMask(u *User, mask uint64) bool {
+	return n[0].matchMask(u, mask) && n[1].matchMask(u, mask)
+}
+func (n orNode) matchMask(u *User, mask uint64) bool {
+	return n[0].matchMask(u, mask) || n[1].matchMask(u, mask)
+}
+func (n notNode) matchMask(u *User, mask uint64) bool { return !n.node.matchMask(u, mask) }
+
+// Match reports whether the user passes the query.
+func (q *Query) Match(u *User) bool {
+	return q.expr.match(u)
+}
+
+// MatchBrowser reports whether browser is matched by a browsers term of the
+// query not under a NOT, whether or not the user having it passes the whole
+// query.
+func (q *Query) MatchBrowser(browser string) bool {
+	for _, t := range q.browsers {
+		if t.counted && t.matchString(browser) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCount is Match also passing the browsers of u accepted by
+// MatchBrowser to seen, matching each browser against the terms once.
+func (q *Query) MatchCount(u *User, seen func(browser string)) bool {
+	return q.expr.matchMask(u, q.browserMask(u, seen))
+}
+
+// browserMask returns the browsers terms matching a browser of u, bit i
+// standing for the term of index i, and passes the browsers matched by a
+// counted term to seen. Terms past the 64th are left to match.
+func (q *Query) browserMask(u *User, seen func(browser string)) uint64 {
+	var mask uint64
+	for _, browser := range u.Browsers {
+		counted := false
+		for _, t := range q.browsers {
+			if !t.matchString(browser) {
+				continue
+			}
+			if t.index < 64 {
+				mask |= 1 << t.index
+			}
+			counted = counted || t.counted
+		}
+		if counted {
+			seen(browser)
+		}
+	}
+	return mask
+}
+
+// ParseQuery parses a filter expression, see Query for the syntax.
+func ParseQuery(s string) (*Query, error) {
+	p := &parser{src: s}
+	p.next()
+	expr := p.or()
+	if p.err == nil && p.tok != "" {
+		p.fail("unexpected %q", p.tok)
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("query %q: %v", s, p.err)
+	}
+	return &Query{expr: expr, browsers: p.browsers}, nil
+}
+
+// MustParseQuery is like ParseQuery but panics if the expression can't be
+// parsed.
+func MustParseQuery(s string) *Query {
+	q, err := ParseQuery(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// parser is a recursive descent parser, it stops at the first error and
+// keeps it in err.
+type parser struct {
+	src      string
+	pos      int
+	tok      string // current token, "" at the end of src
+	err      error
+	browsers []*term
+	negated  bool // under an odd number of NOTs
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("at %d: %s", p.pos-len(p.tok), fmt.Sprintf(format, args...))
+	}
+}
+
+// next moves to the next token: a word, a quoted string, or one of ~ = ( ).
+func (p *parser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	switch {
+	case p.pos == len(p.src):
+	case strings.IndexByte("~=()", p.src[p.pos]) >= 0:
+		p.pos++
+	case p.src[p.pos] == '"':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			p.tok = p.src[start:]
+			p.fail("unterminated string")
+			return
+		}
+		p.pos++
+	default:
+		for p.pos < len(p.src) && isWordByte(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos == start {
+			p.pos++
+		}
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func (p *parser) keyword(kw string) bool {
+	if strings.EqualFold(p.tok, kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() node {
+	n := p.and()
+	for p.err == nil && p.keyword("OR") {
+		n = orNode{n, p.and()}
+	}
+	return n
+}
+
+func (p *parser) and() node {
+	n := p.not()
+	for p.err == nil && p.keyword("AND") {
+		n = andNode{n, p.not()}
+	}
+	return n
+}
+
+func (p *parser) not() node {
+	if p.keyword("NOT") {
+		p.negated = !p.negated
+		n := notNode{p.not()}
+		p.negated = !p.negated
+		return n
+	}
+	if p.tok == "(" {
+		p.next()
+		n := p.or()
+		if p.tok != ")" {
+			p.fail("expected )")
+			return n
+		}
+		p.next()
+		return n
+	}
+	return p.term()
+}
+
+func (p *parser) term() node {
+	t := &term{}
+	f, ok := fields[strings.ToLower(p.tok)]
+	if !ok {
+		p.fail("expected a field, got %q", p.tok)
+		return t
+	}
+	t.field = f
+	p.next()
+
+	if t.op, ok = ops[strings.ToLower(p.tok)]; !ok {
+		p.fail("expected ~, =, starts or ends, got %q", p.tok)
+		return t
+	}
+	p.next()
+
+	if !strings.HasPrefix(p.tok, `"`) {
+		p.fail("expected a quoted string, got %q", p.tok)
+		return t
+	}
+	value, err := strconv.Unquote(p.tok)
+	if err != nil {
+		p.fail("bad string %s", p.tok)
+		return t
+	}
+	t.value = value
+	p.next()
+
+	if t.field == fieldBrowsers {
+		t.index, t.counted = len(p.browsers), !p.negated
+		p.browsers = append(p.browsers, t)
+	}
+	return t
+}
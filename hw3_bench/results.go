@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Result is a user found by a search, on line Line of the file counting
+// from 0.
+type Result struct {
+	Line  int    `json:"line"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Search calls found with each user of the file passing q, in file order,
+// as the users are found, and returns the number of unique browsers
+// matched by the browsers terms of q. It stops at the first error of
+// found.
+func Search(q *Query, found func(Result) error) (int, error) {
+	return scanSearch(q, filePath, found)
+}
+
+// ResultWriter writes the results of a search as they come, without
+// keeping them.
+type ResultWriter interface {
+	WriteResult(r Result) error
+	// WriteTotal ends the output with the number of unique browsers.
+	WriteTotal(uniqueBrowsers int) error
+}
+
+// ResultFormats are the ResultWriter constructors by format name.
+var ResultFormats = map[string]func(w io.Writer) ResultWriter{
+	"text": NewTextWriter,
+	"json": NewJSONWriter,
+	"csv":  NewCSVWriter,
+}
+
+// WriteSearch writes the results of search to w.
+func WriteSearch(w ResultWriter, search func(found func(Result) error) (int, error)) error {
+	uniqueBrowsers, err := search(w.WriteResult)
+	if err != nil {
+		return err
+	}
+	return w.WriteTotal(uniqueBrowsers)
+}
+
+// report writes the text report of search to out, panicking on errors like
+// the searches always did.
+func report(out io.Writer, search func(found func(Result) error) (int, error)) {
+	if err := WriteSearch(NewTextWriter(out), search); err != nil {
+		panic(err)
+	}
+}
+
+type textWriter struct {
+	w       io.Writer
+	buf     []byte
+	started bool
+}
+
+// NewTextWriter writes the report of SlowSearch and FastSearch.
+func NewTextWriter(w io.Writer) ResultWriter {
+	return &textWriter{w: w}
+}
+
+// start empties buf, and begins it with the heading on the first write.
+func (t *textWriter) start() {
+	t.buf = t.buf[:0]
+	if !t.started {
+		t.buf = append(t.buf, "found users:\n"...)
+		t.started = true
+	}
+}
+
+func (t *textWriter) WriteResult(r Result) error {
+	t.start()
+	t.buf = appendFoundUser(t.buf, r)
+	_, err := t.w.Write(t.buf)
+	return err
+}
+
+func (t *textWriter) WriteTotal(uniqueBrowsers int) error {
+	t.start()
+	t.buf = append(t.buf, "\nTotal unique browsers "...)
+	t.buf = strconv.AppendInt(t.buf, int64(uniqueBrowsers), 10)
+	t.buf = append(t.buf, '\n')
+	_, err := t.w.Write(t.buf)
+	return err
+}
+
+// appendFoundUser appends the report line of r to buf.
+func appendFoundUser(buf []byte, r Result) []byte {
+	buf = append(buf, '[')
+	buf = strconv.AppendInt(buf, int64(r.Line), 10)
+	buf = append(buf, "] "...)
+	buf = append(buf, r.Name...)
+	buf = append(buf, " <"...)
+	if at := strings.IndexByte(r.Email, '@'); at >= 0 {
+		buf = append(buf, r.Email[:at]...)
+		buf = append(buf, " [at] "...)
+		buf = append(buf, r.Email[at+1:]...)
+	} else {
+		buf = append(buf, r.Email...)
+	}
+	return append(buf, ">\n"...)
+}
+
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONWriter writes a JSON object per line: the results, then
+// {"unique_browsers": N}.
+func NewJSONWriter(w io.Writer) ResultWriter {
+	return jsonWriter{json.NewEncoder(w)}
+}
+
+func (j jsonWriter) WriteResult(r Result) error {
+	return j.enc.Encode(r)
+}
+
+func (j jsonWriter) WriteTotal(uniqueBrowsers int) error {
+	return j.enc.Encode(struct {
+		UniqueBrowsers int `json:"unique_browsers"`
+	}{uniqueBrowsers})
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	record  []string
+	started bool
+}
+
+// NewCSVWriter writes a line,name,email header and a row per result. The
+// total has no place in the table and is left out.
+func NewCSVWriter(w io.Writer) ResultWriter {
+	return &csvWriter{w: csv.NewWriter(w), record: make([]string, 3)}
+}
+
+func (c *csvWriter) header() error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	return c.w.Write([]string{"line", "name", "email"})
+}
+
+func (c *csvWriter) WriteResult(r Result) error {
+	if err := c.header(); err != nil {
+		return err
+	}
+	c.record[0] = strconv.Itoa(r.Line)
+	c.record[1] = r.Name
+	c.record[2] = r.Email
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) WriteTotal(uniqueBrowsers int) error {
+	if err := c.header(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}